@@ -0,0 +1,298 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// MaxBones is the most bones a shader can be given, the size of finalBonesMatrices in
+// shaders/common/skinning.glsl.
+const MaxBones = 100
+
+// SkeletonNode is a node of the hierarchy that moves the bones. Not every node is a bone,
+// nodes between bones move the bones below them too.
+type SkeletonNode struct {
+	Name string
+	// Parent is the index of the parent node, -1 for roots
+	Parent int
+	// the rest pose, relative to the parent
+	Translation mgl32.Vec3
+	Rotation    mgl32.Quat
+	Scale       mgl32.Vec3
+	// Matrix replaces the rest pose of nodes that are given as a matrix. Those can't be
+	// animated.
+	Matrix *mgl32.Mat4
+}
+
+// Skeleton is a node hierarchy and the nodes in it that vertices are bound to. Vertex BoneIDs
+// index Joints.
+type Skeleton struct {
+	Nodes []SkeletonNode
+	// Joints are the nodes of every bone
+	Joints []int
+	// InverseBindMatrices take vertices from model space into the space of their bone in the
+	// bind pose, one per joint
+	InverseBindMatrices []mgl32.Mat4
+	// order lists the nodes with every parent before its children
+	order []int
+}
+
+// newSkeleton sorts out the evaluation order of the nodes.
+func newSkeleton(nodes []SkeletonNode) *Skeleton {
+	s := &Skeleton{Nodes: nodes}
+	children := make([][]int, len(nodes))
+	var roots []int
+	for i, node := range nodes {
+		if node.Parent < 0 {
+			roots = append(roots, i)
+		} else {
+			children[node.Parent] = append(children[node.Parent], i)
+		}
+	}
+	// roots first, then their children and so on
+	s.order = append(s.order, roots...)
+	for i := 0; i < len(s.order); i++ {
+		s.order = append(s.order, children[s.order[i]]...)
+	}
+	return s
+}
+
+// Animation channel targets
+const (
+	animateTranslation = "translation"
+	animateRotation    = "rotation"
+	animateScale       = "scale"
+)
+
+// Keyframe interpolation, named like glTF does
+const (
+	interpolateLinear      = "LINEAR"
+	interpolateStep        = "STEP"
+	interpolateCubicSpline = "CUBICSPLINE"
+)
+
+// AnimationChannel animates one property of one node.
+type AnimationChannel struct {
+	Node int
+	// Path is translation, rotation or scale
+	Path string
+	// Interpolation is LINEAR, STEP or CUBICSPLINE
+	Interpolation string
+	// Times of the keyframes in seconds, in ascending order
+	Times []float32
+	// Values has the value of every keyframe, 3 floats for translation and scale and a x, y,
+	// z, w quaternion for rotation. Cubic spline keyframes have an in tangent, the value and
+	// an out tangent.
+	Values []float32
+}
+
+// AnimationClip is a named animation of a skeleton.
+type AnimationClip struct {
+	Name     string
+	Duration float32
+	Channels []AnimationChannel
+}
+
+func (c *AnimationChannel) components() int {
+	if c.Path == animateRotation {
+		return 4
+	}
+	return 3
+}
+
+// sample returns the value of the channel at time t, holding the first and last keyframes
+// before and after the animation.
+func (c *AnimationChannel) sample(t float32) [4]float32 {
+	n := c.components()
+	stride := n
+	if c.Interpolation == interpolateCubicSpline {
+		stride = 3 * n
+	}
+	// element 0 is the in tangent, 1 the value and 2 the out tangent of cubic spline keyframes
+	element := func(key, part int) []float32 {
+		offset := key * stride
+		if c.Interpolation == interpolateCubicSpline {
+			offset += part * n
+		}
+		return c.Values[offset : offset+n]
+	}
+
+	var out [4]float32
+	last := len(c.Times) - 1
+	if last < 0 {
+		return out
+	}
+	if t <= c.Times[0] {
+		copy(out[:], element(0, 1))
+		return out
+	}
+	if t >= c.Times[last] {
+		copy(out[:], element(last, 1))
+		return out
+	}
+	key := sort.Search(len(c.Times), func(i int) bool { return c.Times[i] > t }) - 1
+	dt := c.Times[key+1] - c.Times[key]
+	u := (t - c.Times[key]) / dt
+
+	switch c.Interpolation {
+	case interpolateStep:
+		copy(out[:], element(key, 1))
+		return out
+	case interpolateCubicSpline:
+		// tangents are per second, scale them to the length of the keyframe
+		u2 := u * u
+		u3 := u2 * u
+		h00 := 2*u3 - 3*u2 + 1
+		h10 := u3 - 2*u2 + u
+		h01 := -2*u3 + 3*u2
+		h11 := u3 - u2
+		p0, m0 := element(key, 1), element(key, 2)
+		p1, m1 := element(key+1, 1), element(key+1, 0)
+		for i := 0; i < n; i++ {
+			out[i] = h00*p0[i] + h10*dt*m0[i] + h01*p1[i] + h11*dt*m1[i]
+		}
+		if c.Path == animateRotation {
+			out = quatComponents(componentsQuat(out).Normalize())
+		}
+		return out
+	}
+
+	a, b := element(key, 1), element(key+1, 1)
+	if c.Path == animateRotation {
+		q0 := componentsQuat([4]float32{a[0], a[1], a[2], a[3]})
+		q1 := componentsQuat([4]float32{b[0], b[1], b[2], b[3]})
+		// take the short way round
+		if q0.Dot(q1) < 0 {
+			q1 = q1.Scale(-1)
+		}
+		return quatComponents(mgl32.QuatSlerp(q0, q1, u).Normalize())
+	}
+	for i := 0; i < n; i++ {
+		out[i] = a[i] + (b[i]-a[i])*u
+	}
+	return out
+}
+
+// componentsQuat turns x, y, z, w into a quaternion.
+func componentsQuat(v [4]float32) mgl32.Quat {
+	return mgl32.Quat{W: v[3], V: mgl32.Vec3{v[0], v[1], v[2]}}
+}
+
+func quatComponents(q mgl32.Quat) [4]float32 {
+	return [4]float32{q.V[0], q.V[1], q.V[2], q.W}
+}
+
+// nodePose is the transform of a node relative to its parent in the current frame.
+type nodePose struct {
+	translation mgl32.Vec3
+	rotation    mgl32.Quat
+	scale       mgl32.Vec3
+}
+
+func (p nodePose) matrix() mgl32.Mat4 {
+	t := mgl32.Translate3D(p.translation[0], p.translation[1], p.translation[2])
+	s := mgl32.Scale3D(p.scale[0], p.scale[1], p.scale[2])
+	return t.Mul4(p.rotation.Mat4()).Mul4(s)
+}
+
+// Animator plays animation clips on a skeleton and computes the bone matrices for skinning.
+type Animator struct {
+	skeleton *Skeleton
+	clip     *AnimationClip
+	time     float32
+	// speed scales the playback, negative plays backwards
+	speed float32
+	loop  bool
+
+	pose   []nodePose
+	global []mgl32.Mat4
+	// finalBonesMatrices take every bone from the bind pose to the current pose
+	finalBonesMatrices []mgl32.Mat4
+}
+
+// NewAnimator returns an animator holding the skeleton in its rest pose.
+func NewAnimator(skeleton *Skeleton) *Animator {
+	a := &Animator{
+		skeleton:           skeleton,
+		speed:              1,
+		loop:               true,
+		pose:               make([]nodePose, len(skeleton.Nodes)),
+		global:             make([]mgl32.Mat4, len(skeleton.Nodes)),
+		finalBonesMatrices: make([]mgl32.Mat4, len(skeleton.Joints)),
+	}
+	a.update(0)
+	return a
+}
+
+// play starts a clip from the beginning. A nil clip goes back to the rest pose.
+func (a *Animator) play(clip *AnimationClip) {
+	a.clip = clip
+	a.time = 0
+	a.update(0)
+}
+
+// update advances the clip by deltaTime seconds and recomputes the bone matrices.
+func (a *Animator) update(deltaTime float32) {
+	if a.clip != nil {
+		a.time += deltaTime * a.speed
+		duration := a.clip.Duration
+		switch {
+		case duration <= 0:
+			a.time = 0
+		case a.loop:
+			a.time = float32(math.Mod(float64(a.time), float64(duration)))
+			if a.time < 0 {
+				a.time += duration
+			}
+		default:
+			a.time = mgl32.Clamp(a.time, 0, duration)
+		}
+	}
+
+	for i, node := range a.skeleton.Nodes {
+		a.pose[i] = nodePose{translation: node.Translation, rotation: node.Rotation, scale: node.Scale}
+	}
+	if a.clip != nil {
+		for i := range a.clip.Channels {
+			channel := &a.clip.Channels[i]
+			if channel.Node < 0 || channel.Node >= len(a.pose) {
+				continue
+			}
+			value := channel.sample(a.time)
+			pose := &a.pose[channel.Node]
+			switch channel.Path {
+			case animateTranslation:
+				pose.translation = mgl32.Vec3{value[0], value[1], value[2]}
+			case animateRotation:
+				pose.rotation = componentsQuat(value)
+			case animateScale:
+				pose.scale = mgl32.Vec3{value[0], value[1], value[2]}
+			}
+		}
+	}
+
+	for _, i := range a.skeleton.order {
+		node := a.skeleton.Nodes[i]
+		local := a.pose[i].matrix()
+		if node.Matrix != nil {
+			local = *node.Matrix
+		}
+		if node.Parent >= 0 {
+			local = a.global[node.Parent].Mul4(local)
+		}
+		a.global[i] = local
+	}
+	for bone, node := range a.skeleton.Joints {
+		a.finalBonesMatrices[bone] = a.global[node].Mul4(a.skeleton.InverseBindMatrices[bone])
+	}
+}
+
+// upload sets the bone matrices on a shader that skins with finalBonesMatrices.
+func (a *Animator) upload(shader *Shader) {
+	if len(a.finalBonesMatrices) == 0 {
+		return
+	}
+	shader.setMat4Array("finalBonesMatrices", a.finalBonesMatrices[:min(len(a.finalBonesMatrices), MaxBones)])
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// AssetStatus is how far an asset has got.
+type AssetStatus int
+
+const (
+	AssetLoading AssetStatus = iota
+	AssetReady
+	AssetFailed
+)
+
+func (s AssetStatus) String() string {
+	switch s {
+	case AssetLoading:
+		return "loading"
+	case AssetReady:
+		return "ready"
+	case AssetFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// assetHandle tracks an asset loaded by an AssetLoader.
+type assetHandle struct {
+	path string
+	done chan struct{}
+	// err is set before done is closed
+	err error
+}
+
+func newAssetHandle(path string) assetHandle {
+	return assetHandle{path: path, done: make(chan struct{})}
+}
+
+// Path returns the file the asset is loaded from.
+func (h *assetHandle) Path() string {
+	return h.path
+}
+
+// Status returns whether the asset is still loading, ready, or failed to load.
+func (h *assetHandle) Status() AssetStatus {
+	select {
+	case <-h.done:
+		if h.err != nil {
+			return AssetFailed
+		}
+		return AssetReady
+	default:
+		return AssetLoading
+	}
+}
+
+// Err returns why the asset failed to load, nil while it's loading or when it's ready.
+func (h *assetHandle) Err() error {
+	select {
+	case <-h.done:
+		return h.err
+	default:
+		return nil
+	}
+}
+
+// Done is closed when the asset is ready or failed. The uploads happen in
+// AssetLoader.processUploads, so the render thread must never block on it.
+func (h *assetHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+func (h *assetHandle) finish(err error) {
+	h.err = err
+	close(h.done)
+}
+
+// TextureHandle is a texture that is loading.
+type TextureHandle struct {
+	assetHandle
+	id uint32
+}
+
+// ID returns the texture, or the placeholder until it's ready and when it failed.
+func (h *TextureHandle) ID() uint32 {
+	if h.Status() != AssetReady {
+		return placeholderTexture()
+	}
+	return h.id
+}
+
+// ModelHandle is a model that is loading.
+type ModelHandle struct {
+	assetHandle
+	model *Model
+}
+
+// Model returns the model, or nil until it's ready and when it failed.
+func (h *ModelHandle) Model() *Model {
+	if h.Status() != AssetReady {
+		return nil
+	}
+	return h.model
+}
+
+// AssetLoader reads and decodes files on worker goroutines and queues what's left for
+// OpenGL, which only works on the main thread. Call processUploads every frame to create
+// the textures and buffers.
+type AssetLoader struct {
+	// workers holds a slot for every decode that's running
+	workers chan struct{}
+	wg      sync.WaitGroup
+
+	mu      sync.Mutex
+	uploads []func()
+}
+
+// NewAssetLoader returns a loader that decodes at most workers files at a time, or one per
+// CPU when workers isn't positive.
+func NewAssetLoader(workers int) *AssetLoader {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	return &AssetLoader{workers: make(chan struct{}, workers)}
+}
+
+// work runs load on a worker once one is free.
+func (l *AssetLoader) work(load func()) {
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		l.workers <- struct{}{}
+		defer func() { <-l.workers }()
+		load()
+	}()
+}
+
+// workAll runs every load on the workers and waits for them. It's meant for a load that is
+// already on a worker, so it gives up its slot while it waits, or it could wait for itself.
+func (l *AssetLoader) workAll(loads []func()) {
+	var wg sync.WaitGroup
+	wg.Add(len(loads))
+	for _, load := range loads {
+		l.work(func() {
+			defer wg.Done()
+			load()
+		})
+	}
+	<-l.workers
+	wg.Wait()
+	l.workers <- struct{}{}
+}
+
+func (l *AssetLoader) queue(uploads ...func()) {
+	l.mu.Lock()
+	l.uploads = append(l.uploads, uploads...)
+	l.mu.Unlock()
+}
+
+// LoadTexture decodes an image file in the background. Gamma textures are sRGB and get
+// converted to linear when sampled.
+func (l *AssetLoader) LoadTexture(path string, gamma bool) *TextureHandle {
+	h := &TextureHandle{assetHandle: newAssetHandle(path)}
+	l.work(func() {
+		data, err := decodeTextureFile(filepath.FromSlash(path))
+		if err != nil {
+			h.finish(err)
+			return
+		}
+		data.SRGB = gamma
+		l.queue(func() {
+			h.id = data.upload()
+			h.finish(nil)
+		})
+	})
+	return h
+}
+
+// LoadModel loads a model like LoadModelWithOptions, in the background. Its images are decoded
+// in parallel, and its textures and meshes are uploaded one per step, so a big model is spread
+// over a few frames.
+func (l *AssetLoader) LoadModel(path string, options ModelOptions) *ModelHandle {
+	h := &ModelHandle{assetHandle: newAssetHandle(path)}
+	l.work(func() {
+		m, err := loadModelData(path, options, l.workAll)
+		if err != nil {
+			h.finish(err)
+			return
+		}
+		l.queue(append(m.uploadSteps(), func() {
+			h.model = m
+			h.finish(nil)
+		})...)
+	})
+	return h
+}
+
+// processUploads runs queued uploads until budget has passed and returns how many are left.
+// At least one runs every call, so a small budget still gets through the queue.
+func (l *AssetLoader) processUploads(budget time.Duration) int {
+	start := time.Now()
+	for {
+		l.mu.Lock()
+		if len(l.uploads) == 0 {
+			l.mu.Unlock()
+			return 0
+		}
+		upload := l.uploads[0]
+		l.uploads[0] = nil
+		l.uploads = l.uploads[1:]
+		l.mu.Unlock()
+
+		upload()
+
+		if time.Since(start) >= budget {
+			l.mu.Lock()
+			left := len(l.uploads)
+			l.mu.Unlock()
+			return left
+		}
+	}
+}
+
+// Close waits for the workers to finish. Their uploads stay queued.
+func (l *AssetLoader) Close() {
+	l.wg.Wait()
+}
@@ -5,11 +5,15 @@ import (
 	"log"
 	"math"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"runtime"
 
 	"github.com/go-gl/gl/v4.1-core/gl"
 	"github.com/go-gl/glfw/v3.3/glfw"
 	"github.com/go-gl/mathgl/mgl32"
+
+	"github.com/braheezy/learn-opengl/internal/controls"
 )
 
 // Settings
@@ -43,8 +47,7 @@ type Collision struct {
 
 type Game struct {
 	state         GameState
-	keys          [1024]bool
-	keysProcessed [1024]bool
+	input         *controls.Input
 	width, height int
 	levels        []GameLevel
 	currentLevel  int
@@ -60,6 +63,18 @@ var game = Game{
 }
 var renderer *SpriteRenderer
 
+// defaultBindings are the input actions of the game and what triggers them. An input.json
+// next to the shaders can replace them, see controls.Input.LoadBindings.
+var defaultBindings = map[string][]string{
+	"quit":       {"key:Escape"},
+	"move_left":  {"key:A", "key:Left", "gamepad:dpad_left", "axis:left_x-"},
+	"move_right": {"key:D", "key:Right", "gamepad:dpad_right", "axis:left_x+"},
+	"launch":     {"key:Space", "gamepad:a"},
+	"confirm":    {"key:Enter", "gamepad:start", "gamepad:a"},
+	"menu_up":    {"key:W", "key:Up", "gamepad:dpad_up", "axis:left_y-"},
+	"menu_down":  {"key:S", "key:Down", "gamepad:dpad_down", "axis:left_y+"},
+}
+
 var (
 	white          = mgl32.Vec3{1.0, 1.0, 1.0}
 	player         *GameObject
@@ -171,9 +186,9 @@ func (g *Game) Update(deltaTime float64) {
 func (g *Game) ProcessInput(deltaTime float64) {
 	switch g.state {
 	case GameActive:
-		velocity := playerVelocity * deltaTime
-		// move playerboard
-		if g.keys[glfw.KeyA] || g.keys[glfw.KeyLeft] {
+		// move playerboard, slower when a stick is only pushed a little
+		if g.input.Held("move_left") {
+			velocity := playerVelocity * deltaTime * float64(g.input.Value("move_left"))
 			if player.position.X() >= 0.0 {
 				player.position[0] -= float32(velocity)
 				if ball.stuck {
@@ -181,7 +196,8 @@ func (g *Game) ProcessInput(deltaTime float64) {
 				}
 			}
 		}
-		if g.keys[glfw.KeyD] || g.keys[glfw.KeyRight] {
+		if g.input.Held("move_right") {
+			velocity := playerVelocity * deltaTime * float64(g.input.Value("move_right"))
 			if player.position.X() <= float32(g.width)-playerSize.X() {
 				player.position[0] += float32(velocity)
 				if ball.stuck {
@@ -190,41 +206,25 @@ func (g *Game) ProcessInput(deltaTime float64) {
 			}
 		}
 		// player start ball
-		if g.keys[glfw.KeySpace] {
+		if g.input.Held("launch") {
 			ball.stuck = false
 		}
 	case GameMenu:
-		if g.keys[glfw.KeyEnter] && !g.keysProcessed[glfw.KeyEnter] {
+		if g.input.Pressed("confirm") {
 			g.state = GameActive
-			g.keysProcessed[glfw.KeyEnter] = true
 		}
-		if g.keys[glfw.KeyW] && !g.keysProcessed[glfw.KeyW] {
+		if g.input.Pressed("menu_up") {
 			g.currentLevel = (g.currentLevel + 1) % 4
-			g.keysProcessed[glfw.KeyW] = true
-		}
-		if g.keys[glfw.KeyUp] && !g.keysProcessed[glfw.KeyUp] {
-			g.currentLevel = (g.currentLevel + 1) % 4
-			g.keysProcessed[glfw.KeyUp] = true
-		}
-		if g.keys[glfw.KeyDown] && !g.keysProcessed[glfw.KeyDown] {
-			if g.currentLevel > 0 {
-				g.currentLevel--
-			} else {
-				g.currentLevel = 3
-			}
-			g.keysProcessed[glfw.KeyDown] = true
 		}
-		if g.keys[glfw.KeyS] && !g.keysProcessed[glfw.KeyS] {
+		if g.input.Pressed("menu_down") {
 			if g.currentLevel > 0 {
 				g.currentLevel--
 			} else {
 				g.currentLevel = 3
 			}
-			g.keysProcessed[glfw.KeyS] = true
 		}
 	case GameWin:
-		if g.keys[glfw.KeyEnter] {
-			g.keysProcessed[glfw.KeyEnter] = true
+		if g.input.Pressed("confirm") {
 			effects.chaos = false
 			g.state = GameActive
 		}
@@ -528,8 +528,6 @@ func main() {
 	//* Callbacks
 	// Set the function that is run every time the viewport is resized by the user.
 	window.SetFramebufferSizeCallback(framebufferSizeCallback)
-	// Listen to mouse events
-	window.SetKeyCallback(keyCallback)
 
 	//* Load OS-specific OpenGL function pointers
 	if err := gl.Init(); err != nil {
@@ -541,6 +539,18 @@ func main() {
 	gl.Enable(gl.BLEND)
 	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
 
+	//* pick up shader edits and input bindings when running from a source checkout
+	game.input = controls.NewInput(defaultBindings)
+	for _, dir := range []string{"breakout", "."} {
+		if _, err := os.Stat(filepath.Join(dir, "shaders", "sprite.vs")); err == nil {
+			WatchShaders(dir)
+			if err := game.input.LoadBindings(filepath.Join(dir, "input.json")); err != nil {
+				log.Printf("failed to load input bindings: %v", err)
+			}
+			break
+		}
+	}
+
 	//* initialize game
 	game.Init()
 
@@ -556,11 +566,18 @@ func main() {
 		glfw.PollEvents()
 
 		//* manage user input
+		game.input.Update(window)
+		if game.input.Pressed("quit") {
+			window.SetShouldClose(true)
+		}
 		game.ProcessInput(deltaTime)
 
 		//* update game state
 		game.Update(deltaTime)
 
+		//* pick up edited shaders
+		ReloadShaders()
+
 		//* render
 		gl.ClearColor(0.0, 0.0, 0.0, 1.0)
 		gl.Clear(gl.COLOR_BUFFER_BIT)
@@ -574,18 +591,3 @@ func main() {
 func framebufferSizeCallback(w *glfw.Window, width int, height int) {
 	gl.Viewport(0, 0, int32(width), int32(height))
 }
-
-// keyCallback is called when the gl viewport is resized.
-func keyCallback(w *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
-	if w.GetKey(glfw.KeyEscape) == glfw.Press {
-		w.SetShouldClose(true)
-	}
-	if key >= 0 && key < 1024 {
-		if action == glfw.Press {
-			game.keys[key] = true
-		} else if action == glfw.Release {
-			game.keys[key] = false
-			game.keysProcessed[key] = false
-		}
-	}
-}
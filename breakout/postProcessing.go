@@ -5,6 +5,7 @@ import (
 	"unsafe"
 
 	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
 )
 
 // PostProcessor hosts all PostProcessing effects for the Breakout
@@ -55,7 +56,7 @@ func NewPostProcessor(shader *Shader, width, height int32) *PostProcessor {
 	pp.shader.use()
 	pp.shader.setInt("scene", 0)
 	offset := float32(1.0 / 300.0)
-	offsets := [][]float32{
+	offsets := []mgl32.Vec2{
 		{-offset, offset},  // top-left
 		{0.0, offset},      // top-center
 		{offset, offset},   // top-right
@@ -66,19 +67,19 @@ func NewPostProcessor(shader *Shader, width, height int32) *PostProcessor {
 		{0.0, -offset},     // bottom-center
 		{offset, -offset},  // bottom-right
 	}
-	gl.Uniform2fv(gl.GetUniformLocation(pp.shader.id, gl.Str("offsets\x00")), 9, &offsets[0][0])
+	pp.shader.setVec2Array("offsets", offsets)
 	edge_kernel := []int32{
 		-1, -1, -1,
 		-1, 8, -1,
 		-1, -1, -1,
 	}
-	gl.Uniform1iv(gl.GetUniformLocation(pp.shader.id, gl.Str("edge_kernel\x00")), 9, &edge_kernel[0])
+	pp.shader.setIntArray("edge_kernel", edge_kernel)
 	blur_kernel := []float32{
 		1.0 / 16.0, 2.0 / 16.0, 1.0 / 16.0,
 		2.0 / 16.0, 4.0 / 16.0, 2.0 / 16.0,
 		1.0 / 16.0, 2.0 / 16.0, 1.0 / 16.0,
 	}
-	gl.Uniform1fv(gl.GetUniformLocation(pp.shader.id, gl.Str("blur_kernel\x00")), 9, &blur_kernel[0])
+	pp.shader.setFloatArray("blur_kernel", blur_kernel)
 
 	return &pp
 }
@@ -2,10 +2,12 @@ package main
 
 import (
 	"embed"
+	"fmt"
 	"image"
 	_ "image/jpeg"
 	_ "image/png"
 	"log"
+	"strings"
 
 	"github.com/go-gl/gl/v4.1-core/gl"
 )
@@ -13,6 +15,8 @@ import (
 type ResourceManager struct {
 	shaders  map[string]*Shader
 	textures map[string]*Texture2D
+	// directory on disk to watch shader sources in, empty when hot reload is off
+	shaderDir string
 }
 
 // Global instance of the resource manager.
@@ -37,6 +41,9 @@ func LoadShader(vShaderFile, fShaderFile, gShaderFile, name string) *Shader {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if manager.shaderDir != "" {
+		manager.shaders[name].watch(manager.shaderDir)
+	}
 	return manager.shaders[name]
 }
 
@@ -44,6 +51,23 @@ func GetShader(name string) *Shader {
 	return manager.shaders[name]
 }
 
+// WatchShaders reloads shaders from dir on disk when their files change, instead of using the
+// embedded copies. dir is the directory holding shaders/, usually the breakout source directory.
+func WatchShaders(dir string) {
+	manager.shaderDir = dir
+	for _, shader := range manager.shaders {
+		shader.watch(dir)
+	}
+}
+
+// ReloadShaders rebuilds every watched shader whose sources changed. It must be called from
+// the render loop.
+func ReloadShaders() {
+	for _, shader := range manager.shaders {
+		shader.reloadIfChanged()
+	}
+}
+
 func LoadTexture(file string, alpha bool, name string) *Texture2D {
 	manager.textures[name] = loadTextureFromFile(file, alpha)
 	return manager.textures[name]
@@ -55,33 +79,28 @@ func GetTexture(name string) *Texture2D {
 
 func loadShaderFromFile(vShaderFile, fShaderFile, gShaderFile string) (*Shader, error) {
 	// * 1. Retrieve the vertex/fragment source code from file paths
-	var vertexCode, fragmentCode, geometryCode string
-
-	// Read vertex shader file
-	vBytes, err := shaderFiles.ReadFile(vShaderFile)
-	if err != nil {
-		log.Fatalf("ERROR::SHADER: Failed to read vertex shader file: %v", err)
-	}
-	vertexCode = string(vBytes)
-
-	// Read fragment shader file
-	fBytes, err := shaderFiles.ReadFile(fShaderFile)
-	if err != nil {
-		log.Fatalf("ERROR::SHADER: Failed to read fragment shader file: %v", err)
+	stages := []shaderStage{
+		{kind: gl.VERTEX_SHADER, name: "VERTEX", path: vShaderFile},
+		{kind: gl.FRAGMENT_SHADER, name: "FRAGMENT", path: fShaderFile},
 	}
-	fragmentCode = string(fBytes)
-
 	// Read geometry shader file if provided
 	if gShaderFile != "" {
-		gBytes, err := shaderFiles.ReadFile(gShaderFile)
-		if err != nil {
-			log.Fatalf("ERROR::SHADER: Failed to read geometry shader file: %v", err)
+		stages = append(stages, shaderStage{kind: gl.GEOMETRY_SHADER, name: "GEOMETRY", path: gShaderFile})
+	}
+	for i := range stages {
+		if err := stages[i].load(shaderFiles.ReadFile); err != nil {
+			return nil, fmt.Errorf("failed to read %v shader file: %w", strings.ToLower(stages[i].name), err)
 		}
-		geometryCode = string(gBytes)
 	}
 
 	// 2. Now create shader object from source code
-	return NewShader(vertexCode, fragmentCode, geometryCode)
+	id, err := linkProgram(stages)
+	if err != nil {
+		return nil, err
+	}
+	s := &Shader{id: id, stages: stages}
+	s.reflect()
+	return s, nil
 }
 
 func loadTextureFromFile(file string, alpha bool) *Texture2D {
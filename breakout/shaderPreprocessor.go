@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// sourceLine is the file and line a line of a preprocessed shader came from.
+type sourceLine struct {
+	path string
+	line int
+}
+
+// includeError is a failed #include along with the directive that triggered it.
+type includeError struct {
+	from sourceLine
+	err  error
+}
+
+func (e *includeError) Error() string {
+	return fmt.Sprintf("%v:%v: %v", e.from.path, e.from.line, e.err)
+}
+
+func (e *includeError) Unwrap() error {
+	return e.err
+}
+
+// shaderPreprocessor flattens #include directives in GLSL sources. Paths use forward
+// slashes so they work with the embedded shaderFiles. It keeps track of where every output
+// line came from so compiler errors can point at the original file.
+type shaderPreprocessor struct {
+	readFile func(path string) ([]byte, error)
+	// root is the directory of the top level shader, the fallback for include lookups
+	root string
+	// files on the current include chain, for cycle detection
+	stack []string
+	// every file read so far. Files are only included once.
+	files []string
+
+	out   strings.Builder
+	lines []sourceLine
+}
+
+// preprocessShader expands the #include directives of the shader at name.
+func preprocessShader(name string, readFile func(string) ([]byte, error)) (source string, lines []sourceLine, files []string, err error) {
+	p := shaderPreprocessor{readFile: readFile, root: path.Dir(name)}
+	if err := p.include(name); err != nil {
+		return "", nil, nil, err
+	}
+	return p.out.String(), p.lines, p.files, nil
+}
+
+func (p *shaderPreprocessor) emit(text string, from sourceLine) {
+	p.out.WriteString(text)
+	p.out.WriteByte('\n')
+	p.lines = append(p.lines, from)
+}
+
+func (p *shaderPreprocessor) include(file string) error {
+	for i, parent := range p.stack {
+		if parent == file {
+			chain := append(append([]string{}, p.stack[i:]...), file)
+			return fmt.Errorf("include cycle: %v", strings.Join(chain, " -> "))
+		}
+	}
+	for _, seen := range p.files {
+		if seen == file {
+			return nil
+		}
+	}
+
+	data, err := p.readFile(file)
+	if err != nil {
+		return err
+	}
+	p.files = append(p.files, file)
+	p.stack = append(p.stack, file)
+	defer func() { p.stack = p.stack[:len(p.stack)-1] }()
+
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	text = strings.TrimSuffix(text, "\n")
+	for i, line := range strings.Split(text, "\n") {
+		from := sourceLine{path: file, line: i + 1}
+		directive := strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(directive, "#include"):
+			name, err := includeName(directive)
+			if err != nil {
+				return &includeError{from: from, err: err}
+			}
+			if err := p.include(p.resolve(file, name)); err != nil {
+				// report the innermost #include that failed
+				if _, ok := err.(*includeError); ok {
+					return err
+				}
+				return &includeError{from: from, err: err}
+			}
+		default:
+			p.emit(line, from)
+		}
+	}
+	return nil
+}
+
+// resolve finds an included file next to the file including it, falling back to the directory
+// of the top level shader.
+func (p *shaderPreprocessor) resolve(from, name string) string {
+	candidate := path.Join(path.Dir(from), name)
+	if _, err := p.readFile(candidate); err == nil {
+		return candidate
+	}
+	return path.Join(p.root, name)
+}
+
+// includeName extracts the file name from an #include "file" or #include <file> directive.
+func includeName(directive string) (string, error) {
+	arg := strings.TrimSpace(strings.TrimPrefix(directive, "#include"))
+	if len(arg) >= 2 && (arg[0] == '"' && arg[len(arg)-1] == '"' || arg[0] == '<' && arg[len(arg)-1] == '>') {
+		return arg[1 : len(arg)-1], nil
+	}
+	return "", fmt.Errorf("malformed #include directive: %v", directive)
+}
+
+// load preprocesses the source file of the stage.
+func (stage *shaderStage) load(readFile func(string) ([]byte, error)) error {
+	source, lines, files, err := preprocessShader(stage.path, readFile)
+	if err != nil {
+		return err
+	}
+	stage.source = source
+	stage.lines = lines
+	stage.files = files
+	return nil
+}
+
+// location maps a line of the preprocessed source back to the file it came from.
+func (stage shaderStage) location(line int) (string, int) {
+	if line >= 1 && line <= len(stage.lines) {
+		from := stage.lines[line-1]
+		return from.path, from.line
+	}
+	if stage.path == "" {
+		return stage.name, line
+	}
+	return stage.path, line
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// How often watched shaders look at their source files for changes.
+const shaderPollInterval = 250 * time.Millisecond
+
+// watch makes the shader recompile and relink in place whenever one of its source files
+// changes. The embedded copies can't change, so the sources are read from dir on disk
+// instead, which should be the breakout source directory.
+func (s *Shader) watch(dir string) {
+	if s.modTimes != nil {
+		return
+	}
+	s.dir = dir
+	s.modTimes = make(map[string]time.Time)
+	s.values = make(map[string]uniformValue)
+	s.recordModTimes()
+}
+
+// recordModTimes remembers the current modification time of every source file.
+func (s *Shader) recordModTimes() {
+	for _, path := range s.sourceFiles() {
+		if info, err := os.Stat(filepath.Join(s.dir, path)); err == nil {
+			s.modTimes[path] = info.ModTime()
+		}
+	}
+}
+
+// sourceFiles lists the files the program is built from, including the ones pulled in with
+// #include.
+func (s *Shader) sourceFiles() []string {
+	var files []string
+	seen := make(map[string]bool)
+	for _, stage := range s.stages {
+		for _, file := range stage.files {
+			if !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	return files
+}
+
+// readFile reads a shader source from the watched directory.
+func (s *Shader) readFile(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.dir, name))
+}
+
+// reloadIfChanged polls the modification times of the shader sources and rebuilds the
+// program if any of them changed. A program that fails to build is reported and the previous
+// one is kept.
+func (s *Shader) reloadIfChanged() bool {
+	if s.modTimes == nil || time.Since(s.lastPoll) < shaderPollInterval {
+		return false
+	}
+	s.lastPoll = time.Now()
+
+	changed := false
+	for _, path := range s.sourceFiles() {
+		info, err := os.Stat(filepath.Join(s.dir, path))
+		if err != nil {
+			// editors often replace files by deleting them first, try again next poll
+			continue
+		}
+		if !info.ModTime().Equal(s.modTimes[path]) {
+			s.modTimes[path] = info.ModTime()
+			changed = true
+		}
+	}
+	if !changed {
+		return false
+	}
+
+	if err := s.reload(); err != nil {
+		log.Printf("shader reload failed, keeping previous program: %v", err)
+		return false
+	}
+	// the new sources may include files that weren't watched before
+	s.recordModTimes()
+	fmt.Printf("reloaded shader %v\n", s.sourceFiles())
+	return true
+}
+
+// reload builds a new program from the sources on disk and swaps it in place of the old one,
+// restoring the uniform values that were set on the old program.
+func (s *Shader) reload() error {
+	stages := make([]shaderStage, len(s.stages))
+	copy(stages, s.stages)
+	for i := range stages {
+		if err := stages[i].load(s.readFile); err != nil {
+			return err
+		}
+	}
+
+	id, err := linkProgram(stages)
+	if err != nil {
+		return err
+	}
+
+	var current int32
+	gl.GetIntegerv(gl.CURRENT_PROGRAM, &current)
+
+	old := s.id
+	gl.DeleteProgram(old)
+	s.id = id
+	s.stages = stages
+
+	s.reflect()
+
+	gl.UseProgram(s.id)
+	for name, value := range s.values {
+		value.apply(s.uniformLocation(name, value.xtype))
+	}
+	if uint32(current) != old {
+		gl.UseProgram(uint32(current))
+	}
+	return nil
+}
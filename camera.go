@@ -13,9 +13,37 @@ const (
 	BACKWARD
 	LEFT
 	RIGHT
+	UP
+	DOWN
 )
 
-// Camera provides a fly camera to navigate a scene
+// CameraMode selects how the camera responds to input.
+type CameraMode int
+
+const (
+	// FlyMode moves the camera freely and turns it in place
+	FlyMode CameraMode = iota
+	// OrbitMode circles the camera around a target point
+	OrbitMode
+)
+
+func (m CameraMode) String() string {
+	if m == OrbitMode {
+		return "orbit"
+	}
+	return "fly"
+}
+
+// viewer is a camera the scene can be drawn from, a Camera or a FlightCamera.
+type viewer interface {
+	getViewMatrix() mgl32.Mat4
+	block() CameraBlock
+	getPosition() mgl32.Vec3
+	cursorRay(x, y, width, height float32) Ray
+	lodView(model mgl32.Mat4, height int) LODView
+}
+
+// Camera provides a fly camera to navigate a scene, or an orbit camera to inspect one
 type Camera struct {
 	// camera attributes
 	position mgl32.Vec3
@@ -29,6 +57,38 @@ type Camera struct {
 	movementSpeed    float32
 	mouseSensitivity float32
 	zoom             float32
+	// projection
+	aspect       float32
+	near, far    float32
+	orthographic bool
+	// orthoHeight is half the height of the view in orthographic mode
+	orthoHeight float32
+	// orbit mode
+	mode           CameraMode
+	target         mgl32.Vec3
+	distance       float32
+	panSensitivity float32
+	// smooth motion. When smooth is set, processKeyboard and processMouseMovement only
+	// collect input and update moves the camera, so it has to be called every frame.
+	smooth bool
+	// acceleration is how quickly the camera gets up to speed, and damping how quickly it
+	// coasts to a stop. Both are rates per second, higher is snappier.
+	acceleration float32
+	damping      float32
+	// mouseSmoothing is roughly the time in seconds it takes to catch up with the mouse. 0
+	// turns the camera right away.
+	mouseSmoothing float32
+	velocity       mgl32.Vec3
+	moveInput      mgl32.Vec3
+	pendingMouse   mgl32.Vec2
+	constrainPitch bool
+	// speed modifiers, held with setSpeedModifiers
+	sprintMultiplier float32
+	slowMultiplier   float32
+	sprinting        bool
+	slowed           bool
+	// scrollAdjustsSpeed makes the scroll wheel change movementSpeed instead of zooming
+	scrollAdjustsSpeed bool
 }
 
 func newCamera() *Camera {
@@ -41,6 +101,17 @@ func newCamera() *Camera {
 		zoom:             45.0,
 		mouseSensitivity: 0.1,
 		movementSpeed:    3,
+		aspect:           float32(windowWidth) / float32(windowHeight),
+		near:             0.1,
+		far:              100.0,
+		orthoHeight:      5.0,
+		distance:         5.0,
+		panSensitivity:   0.002,
+		acceleration:     10,
+		damping:          6,
+		mouseSmoothing:   0.05,
+		sprintMultiplier: 3,
+		slowMultiplier:   0.25,
 	}
 	return &c
 }
@@ -86,20 +157,64 @@ func NewCameraWithScalars(posX, posY, posZ, upX, upY, upZ, yaw, pitch float32) *
 }
 
 func (c *Camera) processKeyboard(direction CameraMovement, deltaTime float32) {
-	velocity := c.movementSpeed * deltaTime
+	var heading mgl32.Vec3
 	switch direction {
 	case FORWARD:
-		c.position = c.position.Add(c.front.Mul(velocity))
+		heading = c.front
 	case BACKWARD:
-		c.position = c.position.Sub(c.front.Mul(velocity))
+		heading = c.front.Mul(-1)
 	case LEFT:
-		c.position = c.position.Sub(c.right.Mul(velocity))
+		heading = c.right.Mul(-1)
 	case RIGHT:
-		c.position = c.position.Add(c.right.Mul(velocity))
+		heading = c.right
+	case UP:
+		heading = c.worldUp
+	case DOWN:
+		heading = c.worldUp.Mul(-1)
+	}
+	if c.smooth {
+		// update works out the velocity from all the keys held this frame
+		c.moveInput = c.moveInput.Add(heading)
+		return
 	}
+	c.move(heading.Mul(c.speed() * deltaTime))
+}
+
+// move slides the camera by offset. The orbit moves along with the camera.
+func (c *Camera) move(offset mgl32.Vec3) {
+	c.position = c.position.Add(offset)
+	c.target = c.target.Add(offset)
+}
+
+// speed is movementSpeed with the sprint and slow modifiers applied.
+func (c *Camera) speed() float32 {
+	speed := c.movementSpeed
+	if c.sprinting {
+		speed *= c.sprintMultiplier
+	}
+	if c.slowed {
+		speed *= c.slowMultiplier
+	}
+	return speed
+}
+
+// setSpeedModifiers tells the camera whether the sprint and slow keys are held.
+func (c *Camera) setSpeedModifiers(sprint, slow bool) {
+	c.sprinting = sprint
+	c.slowed = slow
 }
 
 func (c *Camera) processMouseMovement(xOffset, yOffset float32, constrainPitch bool) {
+	if c.smooth && c.mouseSmoothing > 0 {
+		// update eases into the movement
+		c.pendingMouse = c.pendingMouse.Add(mgl32.Vec2{xOffset, yOffset})
+		c.constrainPitch = constrainPitch
+		return
+	}
+	c.turn(xOffset, yOffset, constrainPitch)
+}
+
+func (c *Camera) turn(xOffset, yOffset float32, constrainPitch bool) {
 	xOffset *= c.mouseSensitivity
 	yOffset *= c.mouseSensitivity
 
@@ -116,9 +231,67 @@ func (c *Camera) processMouseMovement(xOffset, yOffset float32, constrainPitch b
 	}
 
 	c.updateVectors()
+	if c.mode == OrbitMode {
+		c.updateOrbitPosition()
+	}
+}
+
+// update moves a smooth camera with the input collected since the last frame. The easing is
+// exponential, so the motion is the same at any frame rate.
+func (c *Camera) update(deltaTime float32) {
+	if !c.smooth {
+		return
+	}
+
+	if c.moveInput.Len() > 0 {
+		target := c.moveInput.Normalize().Mul(c.speed())
+		blend := 1 - float32(math.Exp(float64(-c.acceleration*deltaTime)))
+		c.velocity = c.velocity.Add(target.Sub(c.velocity).Mul(blend))
+	} else {
+		c.velocity = c.velocity.Mul(float32(math.Exp(float64(-c.damping * deltaTime))))
+		if c.velocity.Len() < 0.001 {
+			c.velocity = mgl32.Vec3{}
+		}
+	}
+	c.moveInput = mgl32.Vec3{}
+	c.move(c.velocity.Mul(deltaTime))
+
+	if c.pendingMouse.Len() > 0 {
+		blend := float32(1)
+		if c.mouseSmoothing > 0 {
+			blend = 1 - float32(math.Exp(float64(-deltaTime/c.mouseSmoothing)))
+		}
+		step := c.pendingMouse.Mul(blend)
+		c.pendingMouse = c.pendingMouse.Sub(step)
+		c.turn(step.X(), step.Y(), c.constrainPitch)
+	}
+}
+
+// processMousePan slides the camera sideways and up/down, along with its orbit target.
+func (c *Camera) processMousePan(xOffset, yOffset float32) {
+	// pan faster the further away the target is so it follows the cursor
+	scale := c.panSensitivity * c.distance
+	offset := c.right.Mul(-xOffset * scale).Add(c.up.Mul(-yOffset * scale))
+	c.position = c.position.Add(offset)
+	c.target = c.target.Add(offset)
 }
 
 func (c *Camera) processMouseScroll(yOffset float32) {
+	if c.mode == OrbitMode {
+		// dolly towards the target instead of zooming
+		c.distance *= float32(math.Pow(0.9, float64(yOffset)))
+		if c.distance < 0.1 {
+			c.distance = 0.1
+		}
+		c.updateOrbitPosition()
+		return
+	}
+	if c.scrollAdjustsSpeed {
+		c.movementSpeed *= float32(math.Pow(1.2, float64(yOffset)))
+		c.movementSpeed = mgl32.Clamp(c.movementSpeed, 0.1, 1000)
+		return
+	}
+
 	c.zoom -= yOffset
 	if c.zoom < 1.0 {
 		c.zoom = 1.0
@@ -128,6 +301,50 @@ func (c *Camera) processMouseScroll(yOffset float32) {
 	}
 }
 
+// setMode switches between fly and orbit mode without moving the view. Entering orbit mode
+// puts the target straight ahead at the current orbit distance.
+func (c *Camera) setMode(mode CameraMode) {
+	if mode == OrbitMode && c.mode != OrbitMode {
+		c.target = c.position.Add(c.front.Mul(c.distance))
+	}
+	c.mode = mode
+}
+
+// toggleMode flips between fly and orbit mode.
+func (c *Camera) toggleMode() {
+	if c.mode == OrbitMode {
+		c.setMode(FlyMode)
+	} else {
+		c.setMode(OrbitMode)
+	}
+}
+
+// frameBounds points the camera at the center of a bounding box and backs off until the
+// whole box fits in view. The viewing direction is kept.
+func (c *Camera) frameBounds(bounds AABB) {
+	sphere := bounds.boundingSphere()
+	c.target = sphere.Center
+	radius := sphere.Radius
+	// the box has to fit across too, which is the tighter fit in windows taller than wide
+	fov := float64(mgl32.DegToRad(c.zoom))
+	halfFov := min(fov, horizontalFov(fov, float64(c.aspect))) / 2
+	c.distance = float32(float64(radius) / math.Sin(halfFov))
+	if c.distance < 0.1 {
+		c.distance = 0.1
+	}
+	c.updateOrbitPosition()
+}
+
+// horizontalFov returns the horizontal field of view for a vertical one, both in radians.
+func horizontalFov(fovY, aspect float64) float64 {
+	return 2 * math.Atan(math.Tan(fovY/2)*aspect)
+}
+
+// updateOrbitPosition places the camera on its orbit, looking at the target.
+func (c *Camera) updateOrbitPosition() {
+	c.position = c.target.Sub(c.front.Mul(c.distance))
+}
+
 func (c *Camera) getViewMatrix() mgl32.Mat4 {
 	// The lookAt matrix makes the camera viewpoint look at the given target
 	cameraDirection := c.position.Add(c.front)
@@ -139,6 +356,97 @@ func (c *Camera) getViewMatrix() mgl32.Mat4 {
 	)
 }
 
+// projectionMatrix returns the perspective projection using zoom as the vertical field of
+// view, or an orthographic one.
+func (c *Camera) projectionMatrix() mgl32.Mat4 {
+	if c.orthographic {
+		return mgl32.Ortho(-c.orthoHeight*c.aspect, c.orthoHeight*c.aspect, -c.orthoHeight, c.orthoHeight, c.near, c.far)
+	}
+	return mgl32.Perspective(mgl32.DegToRad(c.zoom), c.aspect, c.near, c.far)
+}
+
+// setAspect updates the aspect ratio for a new framebuffer size.
+func (c *Camera) setAspect(width, height int) {
+	// minimized windows have a zero sized framebuffer
+	if width <= 0 || height <= 0 {
+		return
+	}
+	c.aspect = float32(width) / float32(height)
+}
+
+// frustum returns the planes of the visible volume in the local space of an object drawn with
+// the model matrix. Use mgl32.Ident4() for world space.
+func (c *Camera) frustum(model mgl32.Mat4) Frustum {
+	return extractFrustum(c.projectionMatrix().Mul4(c.getViewMatrix()).Mul4(model))
+}
+
+// lodView returns where an object drawn with the model matrix is seen from, on a viewport
+// height pixels high, for Model.Draw to pick levels of detail. The model matrix should scale
+// the same along every axis.
+func (c *Camera) lodView(model mgl32.Mat4, height int) LODView {
+	if c.orthographic {
+		scale := model.Col(0).Vec3().Len()
+		return LODView{PixelsPerUnit: float32(height) / (2 * c.orthoHeight) * scale, Orthographic: true}
+	}
+	// sizes and distances scale alike, so their ratio is the same in the model's space
+	return LODView{
+		Eye:           model.Inv().Mul4x1(c.position.Vec4(1)).Vec3(),
+		PixelsPerUnit: float32(height) / (2 * float32(math.Tan(float64(mgl32.DegToRad(c.zoom))/2))),
+	}
+}
+
+// cursorRay returns the world space ray through a cursor position, with x and y in the same
+// units as width and height and measured from the top left like GLFW does. The ray starts on
+// the near plane and its direction is normalized.
+func (c *Camera) cursorRay(x, y, width, height float32) Ray {
+	return unprojectCursor(c.projectionMatrix().Mul4(c.getViewMatrix()), x, y, width, height)
+}
+
+// unprojectCursor returns the ray through a cursor position for a projection * view matrix.
+func unprojectCursor(viewProjection mgl32.Mat4, x, y, width, height float32) Ray {
+	ndcX := 2*x/width - 1
+	ndcY := 1 - 2*y/height
+	inverse := viewProjection.Inv()
+	unproject := func(z float32) mgl32.Vec3 {
+		p := inverse.Mul4x1(mgl32.Vec4{ndcX, ndcY, z, 1})
+		return p.Vec3().Mul(1 / p.W())
+	}
+	near := unproject(-1)
+	far := unproject(1)
+	return Ray{Origin: near, Direction: far.Sub(near).Normalize()}
+}
+
+func (c *Camera) getPosition() mgl32.Vec3 {
+	return c.position
+}
+
+// block returns the data of the Camera uniform block for this frame.
+func (c *Camera) block() CameraBlock {
+	return CameraBlock{
+		Projection: c.projectionMatrix(),
+		View:       c.getViewMatrix(),
+		ViewPos:    c.position,
+	}
+}
+
+// orientation returns the rotation of the camera as a quaternion.
+func (c *Camera) orientation() mgl32.Quat {
+	// the columns of the camera's rotation are its right, up and back axes
+	basis := mgl32.Mat3FromCols(c.right, c.up, c.front.Mul(-1))
+	return mgl32.Mat4ToQuat(basis.Mat4()).Normalize()
+}
+
+// setOrientation turns the camera to a rotation. Camera can't roll, so any roll in q is lost.
+func (c *Camera) setOrientation(q mgl32.Quat) {
+	front := q.Rotate(mgl32.Vec3{0, 0, -1})
+	c.pitch = mgl32.RadToDeg(float32(math.Asin(float64(mgl32.Clamp(front.Y(), -1, 1)))))
+	c.yaw = mgl32.RadToDeg(float32(math.Atan2(float64(front.Z()), float64(front.X()))))
+	c.updateVectors()
+	if c.mode == OrbitMode {
+		c.target = c.position.Add(c.front.Mul(c.distance))
+	}
+}
+
 func (c *Camera) updateVectors() {
 	// calculate new front vector
 	front := mgl32.Vec3{
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// CameraKeyframe is a camera pose at a point in time, in seconds from the start of the path.
+type CameraKeyframe struct {
+	Time        float32    `json:"time"`
+	Position    mgl32.Vec3 `json:"position"`
+	Orientation mgl32.Quat `json:"orientation"`
+}
+
+// CameraPath is a recorded camera flight. Positions are interpolated with a Catmull-Rom
+// spline through the keyframes and orientations with slerp.
+type CameraPath struct {
+	Keyframes []CameraKeyframe `json:"keyframes"`
+}
+
+// record adds the current pose of the camera as a keyframe at time t.
+func (p *CameraPath) record(c *Camera, t float32) {
+	p.Keyframes = append(p.Keyframes, CameraKeyframe{Time: t, Position: c.position, Orientation: c.orientation()})
+	sort.SliceStable(p.Keyframes, func(i, j int) bool { return p.Keyframes[i].Time < p.Keyframes[j].Time })
+}
+
+// duration is the time of the last keyframe.
+func (p *CameraPath) duration() float32 {
+	if len(p.Keyframes) == 0 {
+		return 0
+	}
+	return p.Keyframes[len(p.Keyframes)-1].Time
+}
+
+// sample returns the camera pose at time t. Times outside the path are clamped to its ends.
+func (p *CameraPath) sample(t float32) (position mgl32.Vec3, orientation mgl32.Quat) {
+	k := p.Keyframes
+	switch {
+	case len(k) == 0:
+		return mgl32.Vec3{}, mgl32.QuatIdent()
+	case t <= k[0].Time:
+		return k[0].Position, k[0].Orientation
+	case t >= k[len(k)-1].Time:
+		return k[len(k)-1].Position, k[len(k)-1].Orientation
+	}
+
+	// find the segment from k[i] to k[i+1] that contains t
+	i := sort.Search(len(k), func(i int) bool { return k[i].Time > t }) - 1
+	segment := k[i+1].Time - k[i].Time
+	u := (t - k[i].Time) / segment
+
+	// Catmull-Rom tangents, divided by the time they span so unevenly spaced keyframes
+	// don't make the camera speed up or slow down at every keyframe
+	m0 := p.tangent(i).Mul(segment)
+	m1 := p.tangent(i + 1).Mul(segment)
+	position = hermite(k[i].Position, m0, k[i+1].Position, m1, u)
+	orientation = mgl32.QuatSlerp(k[i].Orientation, k[i+1].Orientation, u)
+	return position, orientation
+}
+
+// tangent is the velocity of the path at keyframe i. The ends use a one sided difference.
+func (p *CameraPath) tangent(i int) mgl32.Vec3 {
+	k := p.Keyframes
+	before, after := max(i-1, 0), min(i+1, len(k)-1)
+	dt := k[after].Time - k[before].Time
+	if dt <= 0 {
+		return mgl32.Vec3{}
+	}
+	return k[after].Position.Sub(k[before].Position).Mul(1 / dt)
+}
+
+// hermite evaluates the cubic Hermite spline from p0 to p1 with tangents m0 and m1 at u in [0, 1].
+func hermite(p0, m0, p1, m1 mgl32.Vec3, u float32) mgl32.Vec3 {
+	u2 := u * u
+	u3 := u2 * u
+	h00 := 2*u3 - 3*u2 + 1
+	h10 := u3 - 2*u2 + u
+	h01 := -2*u3 + 3*u2
+	h11 := u3 - u2
+	return p0.Mul(h00).Add(m0.Mul(h10)).Add(p1.Mul(h01)).Add(m1.Mul(h11))
+}
+
+// Save writes the path to a JSON file.
+func (p *CameraPath) Save(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// LoadCameraPath reads a path saved with Save.
+func LoadCameraPath(path string) (*CameraPath, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p CameraPath
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	sort.SliceStable(p.Keyframes, func(i, j int) bool { return p.Keyframes[i].Time < p.Keyframes[j].Time })
+	return &p, nil
+}
+
+// CameraPathPlayer plays a path back onto a camera. It only moves forward when advance is
+// called, so rendering a path frame by frame with a fixed step always gives the same frames.
+type CameraPathPlayer struct {
+	path *CameraPath
+	// time is the current position on the path in seconds
+	time float32
+	// speed scales how fast time moves, 2 plays the path twice as fast
+	speed   float32
+	loop    bool
+	playing bool
+}
+
+func NewCameraPathPlayer(path *CameraPath) *CameraPathPlayer {
+	return &CameraPathPlayer{path: path, speed: 1}
+}
+
+// advance moves playback forward by deltaTime seconds, scaled by speed. It stops at the end
+// of the path unless looping.
+func (pl *CameraPathPlayer) advance(deltaTime float32) {
+	if !pl.playing {
+		return
+	}
+	pl.seek(pl.time + deltaTime*pl.speed)
+}
+
+// seek jumps to time t on the path.
+func (pl *CameraPathPlayer) seek(t float32) {
+	duration := pl.path.duration()
+	switch {
+	case duration <= 0:
+		t = 0
+	case pl.loop:
+		for t >= duration {
+			t -= duration
+		}
+		for t < 0 {
+			t += duration
+		}
+	case t >= duration:
+		t = duration
+		pl.playing = false
+	case t < 0:
+		t = 0
+	}
+	pl.time = t
+}
+
+// apply moves the camera to the pose at the current time.
+func (pl *CameraPathPlayer) apply(c *Camera) {
+	if len(pl.path.Keyframes) == 0 {
+		return
+	}
+	position, orientation := pl.path.sample(pl.time)
+	c.position = position
+	c.setOrientation(orientation)
+}
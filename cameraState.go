@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// CameraState is everything needed to put a Camera back the way it was.
+type CameraState struct {
+	Position         mgl32.Vec3 `json:"position"`
+	Yaw              float32    `json:"yaw"`
+	Pitch            float32    `json:"pitch"`
+	MovementSpeed    float32    `json:"movementSpeed"`
+	MouseSensitivity float32    `json:"mouseSensitivity"`
+	Zoom             float32    `json:"zoom"`
+	Orthographic     bool       `json:"orthographic,omitempty"`
+	Orbit            bool       `json:"orbit,omitempty"`
+	Target           mgl32.Vec3 `json:"target"`
+	Distance         float32    `json:"distance"`
+}
+
+func (c *Camera) state() CameraState {
+	return CameraState{
+		Position:         c.position,
+		Yaw:              c.yaw,
+		Pitch:            c.pitch,
+		MovementSpeed:    c.movementSpeed,
+		MouseSensitivity: c.mouseSensitivity,
+		Zoom:             c.zoom,
+		Orthographic:     c.orthographic,
+		Orbit:            c.mode == OrbitMode,
+		Target:           c.target,
+		Distance:         c.distance,
+	}
+}
+
+func (c *Camera) setState(s CameraState) {
+	c.position = s.Position
+	c.yaw = s.Yaw
+	c.pitch = s.Pitch
+	c.movementSpeed = s.MovementSpeed
+	c.mouseSensitivity = s.MouseSensitivity
+	c.zoom = s.Zoom
+	c.orthographic = s.Orthographic
+	c.mode = FlyMode
+	if s.Orbit {
+		c.mode = OrbitMode
+	}
+	c.target = s.Target
+	c.distance = s.Distance
+	c.updateVectors()
+}
+
+// CameraSession is the camera state kept between runs: where the camera was when the
+// program closed and the bookmarked views.
+type CameraSession struct {
+	Last      *CameraState           `json:"last,omitempty"`
+	Bookmarks map[string]CameraState `json:"bookmarks"`
+}
+
+// LoadCameraSession reads a session file. A missing file is an empty session.
+func LoadCameraSession(path string) (*CameraSession, error) {
+	session := &CameraSession{Bookmarks: make(map[string]CameraState)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return session, nil
+	}
+	if err != nil {
+		return session, err
+	}
+	if err := json.Unmarshal(data, session); err != nil {
+		return session, fmt.Errorf("%v: %w", path, err)
+	}
+	if session.Bookmarks == nil {
+		session.Bookmarks = make(map[string]CameraState)
+	}
+	return session, nil
+}
+
+// Save writes the session file.
+func (s *CameraSession) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// FlightCamera is a 6-DOF camera for flight and space scenes. Its orientation is a quaternion
+// instead of euler angles, so it can roll and pitch all the way around without gimbal lock.
+type FlightCamera struct {
+	position    mgl32.Vec3
+	orientation mgl32.Quat
+	// camera options
+	movementSpeed    float32
+	mouseSensitivity float32
+	// rollSpeed is in degrees per second
+	rollSpeed float32
+	zoom      float32
+	// projection
+	aspect    float32
+	near, far float32
+}
+
+// NewFlightCamera creates a flight camera at position looking down -Z, like the default Camera.
+func NewFlightCamera(position mgl32.Vec3) *FlightCamera {
+	return &FlightCamera{
+		position:         position,
+		orientation:      mgl32.QuatIdent(),
+		movementSpeed:    3,
+		mouseSensitivity: 0.1,
+		rollSpeed:        90,
+		zoom:             45.0,
+		aspect:           float32(windowWidth) / float32(windowHeight),
+		near:             0.1,
+		far:              100.0,
+	}
+}
+
+// NewFlightCameraFromCamera creates a flight camera with the same view as c.
+func NewFlightCameraFromCamera(c *Camera) *FlightCamera {
+	fc := NewFlightCamera(c.position)
+	fc.orientation = c.orientation()
+	fc.movementSpeed = c.movementSpeed
+	fc.mouseSensitivity = c.mouseSensitivity
+	fc.zoom = c.zoom
+	fc.aspect, fc.near, fc.far = c.aspect, c.near, c.far
+	return fc
+}
+
+func (c *FlightCamera) front() mgl32.Vec3 {
+	return c.orientation.Rotate(mgl32.Vec3{0, 0, -1})
+}
+
+func (c *FlightCamera) right() mgl32.Vec3 {
+	return c.orientation.Rotate(mgl32.Vec3{1, 0, 0})
+}
+
+func (c *FlightCamera) up() mgl32.Vec3 {
+	return c.orientation.Rotate(mgl32.Vec3{0, 1, 0})
+}
+
+// processKeyboard moves the camera along its own axes, so UP and DOWN follow the roll of the
+// camera rather than the world.
+func (c *FlightCamera) processKeyboard(direction CameraMovement, deltaTime float32) {
+	velocity := c.movementSpeed * deltaTime
+	switch direction {
+	case FORWARD:
+		c.position = c.position.Add(c.front().Mul(velocity))
+	case BACKWARD:
+		c.position = c.position.Sub(c.front().Mul(velocity))
+	case LEFT:
+		c.position = c.position.Sub(c.right().Mul(velocity))
+	case RIGHT:
+		c.position = c.position.Add(c.right().Mul(velocity))
+	case UP:
+		c.position = c.position.Add(c.up().Mul(velocity))
+	case DOWN:
+		c.position = c.position.Sub(c.up().Mul(velocity))
+	}
+}
+
+// processMouseMovement turns the camera around its own up and right axes. There's no pitch
+// clamp, looping over the top just works.
+func (c *FlightCamera) processMouseMovement(xOffset, yOffset float32) {
+	yaw := mgl32.DegToRad(-xOffset * c.mouseSensitivity)
+	pitch := mgl32.DegToRad(yOffset * c.mouseSensitivity)
+	c.rotate(yaw, mgl32.Vec3{0, 1, 0})
+	c.rotate(pitch, mgl32.Vec3{1, 0, 0})
+}
+
+// processRoll rolls the camera around its front axis. direction is 1 to roll clockwise and
+// -1 to roll counter-clockwise.
+func (c *FlightCamera) processRoll(direction float32, deltaTime float32) {
+	c.rotate(mgl32.DegToRad(direction*c.rollSpeed*deltaTime), mgl32.Vec3{0, 0, 1})
+}
+
+// rotate turns the camera by angle radians around an axis in camera space.
+func (c *FlightCamera) rotate(angle float32, axis mgl32.Vec3) {
+	// multiplying on the right rotates in local space. Normalize to keep rounding errors from
+	// building up into a skewed view.
+	c.orientation = c.orientation.Mul(mgl32.QuatRotate(angle, axis)).Normalize()
+}
+
+func (c *FlightCamera) processMouseScroll(yOffset float32) {
+	c.zoom -= yOffset
+	if c.zoom < 1.0 {
+		c.zoom = 1.0
+	}
+	if c.zoom > 45.0 {
+		c.zoom = 45.0
+	}
+}
+
+func (c *FlightCamera) getViewMatrix() mgl32.Mat4 {
+	return lookAt(c.position, c.position.Add(c.front()), c.up())
+}
+
+func (c *FlightCamera) getPosition() mgl32.Vec3 {
+	return c.position
+}
+
+// projectionMatrix returns the perspective projection using zoom as the vertical field of
+// view.
+func (c *FlightCamera) projectionMatrix() mgl32.Mat4 {
+	return mgl32.Perspective(mgl32.DegToRad(c.zoom), c.aspect, c.near, c.far)
+}
+
+// setAspect updates the aspect ratio for a new framebuffer size.
+func (c *FlightCamera) setAspect(width, height int) {
+	if width <= 0 || height <= 0 {
+		return
+	}
+	c.aspect = float32(width) / float32(height)
+}
+
+// cursorRay returns the world space ray through a cursor position, see Camera.cursorRay.
+func (c *FlightCamera) cursorRay(x, y, width, height float32) Ray {
+	return unprojectCursor(c.projectionMatrix().Mul4(c.getViewMatrix()), x, y, width, height)
+}
+
+// lodView returns where an object drawn with the model matrix is seen from, see
+// Camera.lodView.
+func (c *FlightCamera) lodView(model mgl32.Mat4, height int) LODView {
+	return LODView{
+		Eye:           model.Inv().Mul4x1(c.position.Vec4(1)).Vec3(),
+		PixelsPerUnit: float32(height) / (2 * float32(math.Tan(float64(mgl32.DegToRad(c.zoom))/2))),
+	}
+}
+
+// block returns the data of the Camera uniform block for this frame.
+func (c *FlightCamera) block() CameraBlock {
+	return CameraBlock{
+		Projection: c.projectionMatrix(),
+		View:       c.getViewMatrix(),
+		ViewPos:    c.position,
+	}
+}
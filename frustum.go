@@ -0,0 +1,149 @@
+package main
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Plane is the set of points p where Normal.Dot(p) + D == 0. Points on the side the normal
+// points to have a positive distance.
+type Plane struct {
+	Normal mgl32.Vec3
+	D      float32
+}
+
+func (p Plane) distance(point mgl32.Vec3) float32 {
+	return p.Normal.Dot(point) + p.D
+}
+
+// Frustum is the six planes bounding the visible volume of a camera, with normals pointing
+// inside: left, right, bottom, top, near and far.
+type Frustum [6]Plane
+
+// extractFrustum pulls the frustum planes out of a projection * view matrix. If a model
+// matrix is multiplied in as well the planes end up in the model's local space, so local
+// bounds can be tested without transforming them.
+func extractFrustum(m mgl32.Mat4) Frustum {
+	// every plane is the last row of the matrix plus or minus one of the others
+	row := [4]mgl32.Vec4{m.Row(0), m.Row(1), m.Row(2), m.Row(3)}
+	coefficients := [6]mgl32.Vec4{
+		row[3].Add(row[0]), // left
+		row[3].Sub(row[0]), // right
+		row[3].Add(row[1]), // bottom
+		row[3].Sub(row[1]), // top
+		row[3].Add(row[2]), // near
+		row[3].Sub(row[2]), // far
+	}
+
+	var f Frustum
+	for i, c := range coefficients {
+		normal := c.Vec3()
+		length := normal.Len()
+		f[i] = Plane{Normal: normal.Mul(1 / length), D: c.W() / length}
+	}
+	return f
+}
+
+// containsSphere reports whether any part of the sphere is inside the frustum.
+func (f Frustum) containsSphere(s BoundingSphere) bool {
+	for _, plane := range f {
+		if plane.distance(s.Center) < -s.Radius {
+			return false
+		}
+	}
+	return true
+}
+
+// intersectsAABB reports whether any part of the box may be inside the frustum. Boxes near
+// the corners of the frustum can be reported as visible when they aren't, which is fine for
+// culling.
+func (f Frustum) intersectsAABB(b AABB) bool {
+	for _, plane := range f {
+		// the corner furthest along the normal is the last one to leave the plane
+		var positive mgl32.Vec3
+		for i := 0; i < 3; i++ {
+			if plane.Normal[i] >= 0 {
+				positive[i] = b.Max[i]
+			} else {
+				positive[i] = b.Min[i]
+			}
+		}
+		if plane.distance(positive) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// AABB is an axis aligned bounding box.
+type AABB struct {
+	Min, Max mgl32.Vec3
+}
+
+// computeAABB returns the box around a set of vertices.
+func computeAABB(vertices []Vertex) AABB {
+	if len(vertices) == 0 {
+		return AABB{}
+	}
+	b := AABB{Min: vertices[0].Position, Max: vertices[0].Position}
+	for _, vertex := range vertices[1:] {
+		b = b.extend(vertex.Position)
+	}
+	return b
+}
+
+// extend grows the box to include point.
+func (b AABB) extend(point mgl32.Vec3) AABB {
+	for i := 0; i < 3; i++ {
+		b.Min[i] = float32(math.Min(float64(b.Min[i]), float64(point[i])))
+		b.Max[i] = float32(math.Max(float64(b.Max[i]), float64(point[i])))
+	}
+	return b
+}
+
+// union returns the box around both boxes.
+func (b AABB) union(other AABB) AABB {
+	return b.extend(other.Min).extend(other.Max)
+}
+
+func (b AABB) center() mgl32.Vec3 {
+	return b.Min.Add(b.Max).Mul(0.5)
+}
+
+// transform returns the box around b after it's transformed by m.
+func (b AABB) transform(m mgl32.Mat4) AABB {
+	// start from the translation and add the smaller and larger contribution of every axis
+	translation := m.Col(3).Vec3()
+	result := AABB{Min: translation, Max: translation}
+	for column := 0; column < 3; column++ {
+		for row := 0; row < 3; row++ {
+			a := m.At(row, column) * b.Min[column]
+			c := m.At(row, column) * b.Max[column]
+			result.Min[row] += float32(math.Min(float64(a), float64(c)))
+			result.Max[row] += float32(math.Max(float64(a), float64(c)))
+		}
+	}
+	return result
+}
+
+// boundingSphere returns the sphere around the box.
+func (b AABB) boundingSphere() BoundingSphere {
+	return BoundingSphere{Center: b.center(), Radius: b.Max.Sub(b.Min).Len() / 2}
+}
+
+// BoundingSphere is a sphere around some geometry, cheaper to test than a box.
+type BoundingSphere struct {
+	Center mgl32.Vec3
+	Radius float32
+}
+
+// computeBoundingSphere returns a sphere around the vertices, centered on their box. It's
+// often much smaller than the sphere around the box, which has to reach its corners.
+func computeBoundingSphere(vertices []Vertex, bounds AABB) BoundingSphere {
+	sphere := BoundingSphere{Center: bounds.center()}
+	for _, vertex := range vertices {
+		sphere.Radius = max(sphere.Radius, vertex.Position.Sub(sphere.Center).Len())
+	}
+	return sphere
+}
@@ -0,0 +1,1092 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// PBRMaterial is a glTF metallic-roughness material. Its textures go in the slots of the
+// mesh Material, see LoadGLTF for which.
+type PBRMaterial struct {
+	Name              string
+	BaseColorFactor   mgl32.Vec4
+	MetallicFactor    float32
+	RoughnessFactor   float32
+	EmissiveFactor    mgl32.Vec3
+	NormalScale       float32
+	OcclusionStrength float32
+	// AlphaMode is OPAQUE, MASK or BLEND. Masked materials discard below AlphaCutoff.
+	AlphaMode   string
+	AlphaCutoff float32
+	DoubleSided bool
+}
+
+// defaultPBRMaterial is what glTF uses for primitives without a material.
+func defaultPBRMaterial() PBRMaterial {
+	return PBRMaterial{
+		BaseColorFactor:   mgl32.Vec4{1, 1, 1, 1},
+		MetallicFactor:    1,
+		RoughnessFactor:   1,
+		NormalScale:       1,
+		OcclusionStrength: 1,
+		AlphaMode:         "OPAQUE",
+		AlphaCutoff:       0.5,
+	}
+}
+
+// material returns the Material for the non PBR uniforms, with the textures in their slots.
+func (m *PBRMaterial) material(textures []Texture) Material {
+	material := materialFromTextures(textures)
+	material.Name = m.Name
+	material.Diffuse = m.BaseColorFactor.Vec3()
+	material.Opacity = m.BaseColorFactor.W()
+	material.Emissive = m.EmissiveFactor
+	return material
+}
+
+// apply sets the material factors on shaders that have a material uniform for them.
+func (m *PBRMaterial) apply(shader *Shader) {
+	if shader.hasUniform("material.baseColorFactor") {
+		shader.setVec4("material.baseColorFactor", m.BaseColorFactor)
+	}
+	if shader.hasUniform("material.metallicFactor") {
+		shader.setFloat("material.metallicFactor", m.MetallicFactor)
+	}
+	if shader.hasUniform("material.roughnessFactor") {
+		shader.setFloat("material.roughnessFactor", m.RoughnessFactor)
+	}
+	if shader.hasUniform("material.emissiveFactor") {
+		shader.setVec3("material.emissiveFactor", m.EmissiveFactor)
+	}
+	if shader.hasUniform("material.alphaCutoff") {
+		cutoff := float32(0)
+		if m.AlphaMode == "MASK" {
+			cutoff = m.AlphaCutoff
+		}
+		shader.setFloat("material.alphaCutoff", cutoff)
+	}
+}
+
+// glTF JSON, only the parts the loader uses.
+type gltfDocument struct {
+	Asset struct {
+		Version string `json:"version"`
+	} `json:"asset"`
+	ExtensionsRequired []string        `json:"extensionsRequired"`
+	Scene              *int            `json:"scene"`
+	Scenes             []gltfScene     `json:"scenes"`
+	Nodes              []gltfNode      `json:"nodes"`
+	Meshes             []gltfMesh      `json:"meshes"`
+	Accessors          []gltfAccessor  `json:"accessors"`
+	BufferViews        []gltfView      `json:"bufferViews"`
+	Buffers            []gltfBuffer    `json:"buffers"`
+	Materials          []gltfMaterial  `json:"materials"`
+	Textures           []gltfTexture   `json:"textures"`
+	Images             []gltfImage     `json:"images"`
+	Skins              []gltfSkin      `json:"skins"`
+	Animations         []gltfAnimation `json:"animations"`
+}
+
+type gltfScene struct {
+	Nodes []int `json:"nodes"`
+}
+
+type gltfNode struct {
+	Name        string    `json:"name"`
+	Children    []int     `json:"children"`
+	Mesh        *int      `json:"mesh"`
+	Skin        *int      `json:"skin"`
+	Matrix      []float32 `json:"matrix"`
+	Translation []float32 `json:"translation"`
+	Rotation    []float32 `json:"rotation"`
+	Scale       []float32 `json:"scale"`
+}
+
+type gltfMesh struct {
+	Name       string          `json:"name"`
+	Primitives []gltfPrimitive `json:"primitives"`
+}
+
+type gltfPrimitive struct {
+	Attributes map[string]int `json:"attributes"`
+	Indices    *int           `json:"indices"`
+	Material   *int           `json:"material"`
+	Mode       *int           `json:"mode"`
+}
+
+type gltfAccessor struct {
+	BufferView    *int            `json:"bufferView"`
+	ByteOffset    int             `json:"byteOffset"`
+	ComponentType int             `json:"componentType"`
+	Normalized    bool            `json:"normalized"`
+	Count         int             `json:"count"`
+	Type          string          `json:"type"`
+	Sparse        json.RawMessage `json:"sparse"`
+}
+
+type gltfView struct {
+	Buffer     int `json:"buffer"`
+	ByteOffset int `json:"byteOffset"`
+	ByteLength int `json:"byteLength"`
+	ByteStride int `json:"byteStride"`
+}
+
+type gltfBuffer struct {
+	URI        string `json:"uri"`
+	ByteLength int    `json:"byteLength"`
+}
+
+type gltfTextureInfo struct {
+	Index    int     `json:"index"`
+	TexCoord int     `json:"texCoord"`
+	Scale    float32 `json:"scale"`
+	Strength float32 `json:"strength"`
+}
+
+type gltfMaterial struct {
+	Name                 string `json:"name"`
+	PbrMetallicRoughness *struct {
+		BaseColorFactor          []float32        `json:"baseColorFactor"`
+		BaseColorTexture         *gltfTextureInfo `json:"baseColorTexture"`
+		MetallicFactor           *float32         `json:"metallicFactor"`
+		RoughnessFactor          *float32         `json:"roughnessFactor"`
+		MetallicRoughnessTexture *gltfTextureInfo `json:"metallicRoughnessTexture"`
+	} `json:"pbrMetallicRoughness"`
+	NormalTexture    *gltfTextureInfo `json:"normalTexture"`
+	OcclusionTexture *gltfTextureInfo `json:"occlusionTexture"`
+	EmissiveTexture  *gltfTextureInfo `json:"emissiveTexture"`
+	EmissiveFactor   []float32        `json:"emissiveFactor"`
+	AlphaMode        string           `json:"alphaMode"`
+	AlphaCutoff      *float32         `json:"alphaCutoff"`
+	DoubleSided      bool             `json:"doubleSided"`
+}
+
+type gltfSkin struct {
+	Name                string `json:"name"`
+	InverseBindMatrices *int   `json:"inverseBindMatrices"`
+	Joints              []int  `json:"joints"`
+}
+
+type gltfAnimation struct {
+	Name     string `json:"name"`
+	Channels []struct {
+		Sampler int `json:"sampler"`
+		Target  struct {
+			Node *int   `json:"node"`
+			Path string `json:"path"`
+		} `json:"target"`
+	} `json:"channels"`
+	Samplers []struct {
+		Input         int    `json:"input"`
+		Output        int    `json:"output"`
+		Interpolation string `json:"interpolation"`
+	} `json:"samplers"`
+}
+
+type gltfTexture struct {
+	Source *int `json:"source"`
+}
+
+type gltfImage struct {
+	URI        string `json:"uri"`
+	MimeType   string `json:"mimeType"`
+	BufferView *int   `json:"bufferView"`
+}
+
+// Primitive modes
+const (
+	gltfTriangles     = 4
+	gltfTriangleStrip = 5
+	gltfTriangleFan   = 6
+)
+
+// gltfSupportedExtensions are the extensions the loader can handle when a file requires them.
+var gltfSupportedExtensions = map[string]bool{}
+
+// gltfLoader holds a parsed glTF file while its meshes are built.
+type gltfLoader struct {
+	doc     gltfDocument
+	path    string
+	dir     string
+	buffers [][]byte
+	model   *Model
+	// skinBones is the first bone of every skin in the model skeleton
+	skinBones []int
+}
+
+// LoadGLTF loads a glTF 2.0 model, either a .gltf file with external or embedded buffers or a
+// binary .glb file. Every primitive becomes a Mesh. Node transforms are baked into the
+// vertices, except for skinned meshes which stay in their bind pose.
+//
+// The joints of all skins go into one Skeleton, with vertex BoneIDs pointing at its bones, and
+// the animations of the file become AnimationClips for it. Play them with an Animator. Only
+// the nodes of the skeleton are animated, the baked transforms of other meshes stay put.
+//
+// The material textures go in these slots: SlotDiffuse (base color), SlotMetallicRoughness,
+// SlotNormal, SlotOcclusion and SlotEmissive.
+func LoadGLTF(path string) (*Model, error) {
+	m, err := loadGLTF(path, ModelOptions{})
+	if err != nil {
+		return nil, err
+	}
+	m.upload()
+	return m, nil
+}
+
+func loadGLTF(path string, options ModelOptions) (*Model, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &gltfLoader{
+		path:  path,
+		dir:   filepath.Dir(path),
+		model: newModel(filepath.Dir(path), options),
+	}
+	l.model.source(path)
+
+	jsonChunk := data
+	var binChunk []byte
+	if strings.EqualFold(filepath.Ext(path), ".glb") {
+		jsonChunk, binChunk, err = splitGLB(data)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", path, err)
+		}
+	}
+	if err := json.Unmarshal(jsonChunk, &l.doc); err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	if err := l.load(binChunk); err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	return l.model, nil
+}
+
+// splitGLB returns the JSON and binary chunks of a .glb file.
+func splitGLB(data []byte) (jsonChunk, binChunk []byte, err error) {
+	if len(data) < 20 || string(data[:4]) != "glTF" {
+		return nil, nil, errors.New("not a binary glTF file")
+	}
+	if version := binary.LittleEndian.Uint32(data[4:]); version != 2 {
+		return nil, nil, fmt.Errorf("binary glTF version %v is not supported", version)
+	}
+	length := int(binary.LittleEndian.Uint32(data[8:]))
+	if length > len(data) {
+		return nil, nil, errors.New("binary glTF file is truncated")
+	}
+
+	for offset := 12; offset+8 <= length; {
+		chunkLength := int(binary.LittleEndian.Uint32(data[offset:]))
+		chunkType := binary.LittleEndian.Uint32(data[offset+4:])
+		start := offset + 8
+		if start+chunkLength > length {
+			return nil, nil, errors.New("binary glTF chunk is truncated")
+		}
+		switch chunkType {
+		case 0x4E4F534A: // JSON
+			jsonChunk = data[start : start+chunkLength]
+		case 0x004E4942: // BIN
+			binChunk = data[start : start+chunkLength]
+		}
+		// chunks are padded to 4 bytes
+		offset = start + (chunkLength+3)/4*4
+	}
+	if jsonChunk == nil {
+		return nil, nil, errors.New("binary glTF file has no JSON chunk")
+	}
+	return jsonChunk, binChunk, nil
+}
+
+func (l *gltfLoader) load(binChunk []byte) error {
+	if !strings.HasPrefix(l.doc.Asset.Version, "2.") {
+		return fmt.Errorf("glTF version %q is not supported, only 2.x", l.doc.Asset.Version)
+	}
+	var unsupported []string
+	for _, extension := range l.doc.ExtensionsRequired {
+		if !gltfSupportedExtensions[extension] {
+			unsupported = append(unsupported, extension)
+		}
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("requires unsupported extensions: %v", strings.Join(unsupported, ", "))
+	}
+
+	for i, buffer := range l.doc.Buffers {
+		var data []byte
+		var err error
+		if buffer.URI == "" {
+			// the first buffer of a .glb without a uri is the binary chunk
+			if i != 0 || binChunk == nil {
+				return fmt.Errorf("buffer %v has no data", i)
+			}
+			data = binChunk
+		} else {
+			data, err = l.readURI(buffer.URI)
+			if err != nil {
+				return fmt.Errorf("buffer %v: %w", i, err)
+			}
+		}
+		if len(data) < buffer.ByteLength {
+			return fmt.Errorf("buffer %v is %v bytes, expected %v", i, len(data), buffer.ByteLength)
+		}
+		l.buffers = append(l.buffers, data)
+	}
+
+	// the skeleton comes first, skinned primitives need its bones
+	if err := l.loadSkins(); err != nil {
+		return err
+	}
+	for _, node := range l.sceneNodes() {
+		if err := l.loadNode(node, mgl32.Ident4(), 0); err != nil {
+			return err
+		}
+	}
+	if l.model.skeleton != nil {
+		return l.loadAnimations()
+	}
+	return nil
+}
+
+// loadSkins puts the joints of every skin into the model skeleton. The skeleton holds every
+// node of the file, so animations can move nodes between joints too.
+func (l *gltfLoader) loadSkins() error {
+	if len(l.doc.Skins) == 0 {
+		return nil
+	}
+
+	nodes := make([]SkeletonNode, len(l.doc.Nodes))
+	for i, node := range l.doc.Nodes {
+		nodes[i] = SkeletonNode{
+			Name:     node.Name,
+			Parent:   -1,
+			Rotation: mgl32.QuatIdent(),
+			Scale:    mgl32.Vec3{1, 1, 1},
+		}
+		if len(node.Matrix) == 16 {
+			matrix := node.localTransform()
+			nodes[i].Matrix = &matrix
+		}
+		if len(node.Translation) == 3 {
+			nodes[i].Translation = mgl32.Vec3{node.Translation[0], node.Translation[1], node.Translation[2]}
+		}
+		if len(node.Rotation) == 4 {
+			nodes[i].Rotation = componentsQuat([4]float32{node.Rotation[0], node.Rotation[1], node.Rotation[2], node.Rotation[3]}).Normalize()
+		}
+		if len(node.Scale) == 3 {
+			nodes[i].Scale = mgl32.Vec3{node.Scale[0], node.Scale[1], node.Scale[2]}
+		}
+	}
+	for i, node := range l.doc.Nodes {
+		for _, child := range node.Children {
+			if child < 0 || child >= len(nodes) {
+				return fmt.Errorf("node %v: child %v does not exist", i, child)
+			}
+			if nodes[child].Parent >= 0 {
+				return fmt.Errorf("node %v has more than one parent", child)
+			}
+			nodes[child].Parent = i
+		}
+	}
+	skeleton := newSkeleton(nodes)
+	if len(skeleton.order) != len(nodes) {
+		return errors.New("node hierarchy has a cycle")
+	}
+
+	for i, skin := range l.doc.Skins {
+		l.skinBones = append(l.skinBones, len(skeleton.Joints))
+		var inverseBind []float32
+		if skin.InverseBindMatrices != nil {
+			var count int
+			var err error
+			inverseBind, count, err = l.readFloats(*skin.InverseBindMatrices, 16)
+			if err != nil {
+				return fmt.Errorf("skin %v: inverseBindMatrices: %w", i, err)
+			}
+			if count < len(skin.Joints) {
+				return fmt.Errorf("skin %v has %v inverse bind matrices for %v joints", i, count, len(skin.Joints))
+			}
+		}
+		for j, joint := range skin.Joints {
+			if joint < 0 || joint >= len(nodes) {
+				return fmt.Errorf("skin %v: joint node %v does not exist", i, joint)
+			}
+			// without inverse bind matrices the joints are bound where they are
+			matrix := mgl32.Ident4()
+			if inverseBind != nil {
+				copy(matrix[:], inverseBind[16*j:16*j+16])
+			}
+			skeleton.Joints = append(skeleton.Joints, joint)
+			skeleton.InverseBindMatrices = append(skeleton.InverseBindMatrices, matrix)
+		}
+	}
+	if len(skeleton.Joints) > MaxBones {
+		l.model.warn("skeleton has %v bones, shaders only take %v", len(skeleton.Joints), MaxBones)
+	}
+	l.model.skeleton = skeleton
+	return nil
+}
+
+// loadAnimations reads the animation clips of the skeleton.
+func (l *gltfLoader) loadAnimations() error {
+	for i, animation := range l.doc.Animations {
+		clip := &AnimationClip{Name: animation.Name}
+		if clip.Name == "" {
+			clip.Name = fmt.Sprintf("animation %v", i)
+		}
+		for j, channel := range animation.Channels {
+			if channel.Target.Node == nil {
+				continue
+			}
+			switch channel.Target.Path {
+			case animateTranslation, animateRotation, animateScale:
+			default:
+				l.model.warn("%v: channel %v animates %v, which is not supported", clip.Name, j, channel.Target.Path)
+				continue
+			}
+			if *channel.Target.Node < 0 || *channel.Target.Node >= len(l.doc.Nodes) {
+				return fmt.Errorf("%v: channel %v: node %v does not exist", clip.Name, j, *channel.Target.Node)
+			}
+			if channel.Sampler < 0 || channel.Sampler >= len(animation.Samplers) {
+				return fmt.Errorf("%v: channel %v: sampler %v does not exist", clip.Name, j, channel.Sampler)
+			}
+			sampler := animation.Samplers[channel.Sampler]
+
+			c := AnimationChannel{
+				Node:          *channel.Target.Node,
+				Path:          channel.Target.Path,
+				Interpolation: sampler.Interpolation,
+			}
+			// cubic spline keyframes have tangents around the value
+			valuesPerKey := 1
+			switch c.Interpolation {
+			case "":
+				c.Interpolation = interpolateLinear
+			case interpolateLinear, interpolateStep:
+			case interpolateCubicSpline:
+				valuesPerKey = 3
+			default:
+				return fmt.Errorf("%v: channel %v: unknown interpolation %v", clip.Name, j, c.Interpolation)
+			}
+
+			var err error
+			c.Times, _, err = l.readFloats(sampler.Input, 1)
+			if err != nil {
+				return fmt.Errorf("%v: channel %v: input: %w", clip.Name, j, err)
+			}
+			var count int
+			c.Values, count, err = l.readFloats(sampler.Output, c.components())
+			if err != nil {
+				return fmt.Errorf("%v: channel %v: output: %w", clip.Name, j, err)
+			}
+			if count != valuesPerKey*len(c.Times) {
+				return fmt.Errorf("%v: channel %v has %v values for %v keyframes", clip.Name, j, count, len(c.Times))
+			}
+			if len(c.Times) == 0 {
+				continue
+			}
+			clip.Duration = max(clip.Duration, c.Times[len(c.Times)-1])
+			clip.Channels = append(clip.Channels, c)
+		}
+		l.model.animations = append(l.model.animations, clip)
+	}
+	return nil
+}
+
+// sceneNodes returns the root nodes of the default scene. Files without scenes use every node
+// that isn't a child of another.
+func (l *gltfLoader) sceneNodes() []int {
+	if len(l.doc.Scenes) > 0 {
+		scene := 0
+		if l.doc.Scene != nil {
+			scene = *l.doc.Scene
+		}
+		if scene >= 0 && scene < len(l.doc.Scenes) {
+			return l.doc.Scenes[scene].Nodes
+		}
+	}
+	isChild := make([]bool, len(l.doc.Nodes))
+	for _, node := range l.doc.Nodes {
+		for _, child := range node.Children {
+			if child >= 0 && child < len(isChild) {
+				isChild[child] = true
+			}
+		}
+	}
+	var roots []int
+	for i := range l.doc.Nodes {
+		if !isChild[i] {
+			roots = append(roots, i)
+		}
+	}
+	return roots
+}
+
+// readURI reads a data: uri or a file relative to the glTF file.
+func (l *gltfLoader) readURI(uri string) ([]byte, error) {
+	if strings.HasPrefix(uri, "data:") {
+		header, payload, ok := strings.Cut(uri, ",")
+		if !ok || !strings.HasSuffix(header, ";base64") {
+			return nil, errors.New("only base64 data uris are supported")
+		}
+		return base64.StdEncoding.DecodeString(payload)
+	}
+	name, err := url.PathUnescape(uri)
+	if err != nil {
+		name = uri
+	}
+	filename := filepath.Join(l.dir, filepath.FromSlash(name))
+	l.model.source(filename)
+	return os.ReadFile(filename)
+}
+
+// localTransform returns the transform of a node relative to its parent.
+func (node gltfNode) localTransform() mgl32.Mat4 {
+	if len(node.Matrix) == 16 {
+		// both glTF and mgl32 store matrices column by column
+		var m mgl32.Mat4
+		copy(m[:], node.Matrix)
+		return m
+	}
+	transform := mgl32.Ident4()
+	if len(node.Translation) == 3 {
+		transform = mgl32.Translate3D(node.Translation[0], node.Translation[1], node.Translation[2])
+	}
+	if len(node.Rotation) == 4 {
+		// glTF quaternions are x, y, z, w
+		rotation := mgl32.Quat{W: node.Rotation[3], V: mgl32.Vec3{node.Rotation[0], node.Rotation[1], node.Rotation[2]}}
+		transform = transform.Mul4(rotation.Normalize().Mat4())
+	}
+	if len(node.Scale) == 3 {
+		transform = transform.Mul4(mgl32.Scale3D(node.Scale[0], node.Scale[1], node.Scale[2]))
+	}
+	return transform
+}
+
+func (l *gltfLoader) loadNode(index int, parent mgl32.Mat4, depth int) error {
+	if index < 0 || index >= len(l.doc.Nodes) {
+		return fmt.Errorf("node %v does not exist", index)
+	}
+	// a well formed file is a tree, guard against cycles in broken ones
+	if depth > len(l.doc.Nodes) {
+		return errors.New("node hierarchy has a cycle")
+	}
+	node := l.doc.Nodes[index]
+	world := parent.Mul4(node.localTransform())
+
+	if node.Mesh != nil {
+		if *node.Mesh < 0 || *node.Mesh >= len(l.doc.Meshes) {
+			return fmt.Errorf("node %v: mesh %v does not exist", index, *node.Mesh)
+		}
+		skin := -1
+		if node.Skin != nil {
+			if *node.Skin < 0 || *node.Skin >= len(l.doc.Skins) {
+				return fmt.Errorf("node %v: skin %v does not exist", index, *node.Skin)
+			}
+			skin = *node.Skin
+		}
+		mesh := l.doc.Meshes[*node.Mesh]
+		for i, primitive := range mesh.Primitives {
+			m, err := l.loadPrimitive(primitive, world, skin)
+			if err != nil {
+				return fmt.Errorf("mesh %q primitive %v: %w", mesh.Name, i, err)
+			}
+			l.model.meshes = append(l.model.meshes, m)
+		}
+	}
+
+	for _, child := range node.Children {
+		if err := l.loadNode(child, world, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadPrimitive builds the mesh of a primitive. skin is the skin of the node, -1 if it has
+// none. Primitives without joints and weights aren't skinned, even on a node with a skin, and
+// get transform baked in like every other mesh.
+func (l *gltfLoader) loadPrimitive(primitive gltfPrimitive, transform mgl32.Mat4, skin int) (Mesh, error) {
+	mode := gltfTriangles
+	if primitive.Mode != nil {
+		mode = *primitive.Mode
+	}
+	if mode != gltfTriangles && mode != gltfTriangleStrip && mode != gltfTriangleFan {
+		return Mesh{}, fmt.Errorf("primitive mode %v is not supported, only triangles", mode)
+	}
+
+	positionAccessor, ok := primitive.Attributes["POSITION"]
+	if !ok {
+		return Mesh{}, errors.New("primitive has no POSITION attribute")
+	}
+	positions, _, err := l.readFloats(positionAccessor, 3)
+	if err != nil {
+		return Mesh{}, fmt.Errorf("POSITION: %w", err)
+	}
+	vertices := make([]Vertex, len(positions)/3)
+
+	// optional attributes, every one has to have an entry per vertex
+	attribute := func(name string, components int) ([]float32, error) {
+		accessor, ok := primitive.Attributes[name]
+		if !ok {
+			return nil, nil
+		}
+		values, count, err := l.readFloats(accessor, components)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", name, err)
+		}
+		if count != len(vertices) {
+			return nil, fmt.Errorf("%v has %v elements for %v vertices", name, count, len(vertices))
+		}
+		return values, nil
+	}
+	normals, err := attribute("NORMAL", 3)
+	if err != nil {
+		return Mesh{}, err
+	}
+	tangents, err := attribute("TANGENT", 4)
+	if err != nil {
+		return Mesh{}, err
+	}
+	texCoords, err := attribute("TEXCOORD_0", 2)
+	if err != nil {
+		return Mesh{}, err
+	}
+	weights, err := attribute("WEIGHTS_0", 4)
+	if err != nil {
+		return Mesh{}, err
+	}
+	var joints []uint32
+	if accessor, ok := primitive.Attributes["JOINTS_0"]; ok {
+		joints, err = l.readUints(accessor)
+		if err != nil {
+			return Mesh{}, fmt.Errorf("JOINTS_0: %w", err)
+		}
+		if len(joints) != 4*len(vertices) {
+			return Mesh{}, fmt.Errorf("JOINTS_0 has %v elements for %v vertices", len(joints)/4, len(vertices))
+		}
+	}
+	skinned := skin >= 0 && joints != nil && weights != nil
+	if skinned {
+		// joints index the joints of the skin, turn them into bones of the model skeleton
+		jointCount := uint32(len(l.doc.Skins[skin].Joints))
+		for i, joint := range joints {
+			if joint >= jointCount {
+				// unused influences may point anywhere
+				if weights[i] != 0 {
+					return Mesh{}, fmt.Errorf("JOINTS_0 uses joint %v of a skin with %v", joint, jointCount)
+				}
+				joint = 0
+			}
+			joints[i] = joint + uint32(l.skinBones[skin])
+		}
+		// skinned vertices are moved by their joints, the node transform doesn't apply
+		transform = mgl32.Ident4()
+	}
+
+	// normals and tangents are directions, they are transformed without the translation
+	// and normals with the inverse transpose to survive non-uniform scaling
+	linear := transform.Mat3()
+	normalMatrix := linear.Inv().Transpose()
+	for i := range vertices {
+		v := &vertices[i]
+		v.Position = transform.Mul4x1(mgl32.Vec3{positions[3*i], positions[3*i+1], positions[3*i+2]}.Vec4(1)).Vec3()
+		if normals != nil {
+			v.Normal = normalizeOrZero(normalMatrix.Mul3x1(mgl32.Vec3{normals[3*i], normals[3*i+1], normals[3*i+2]}))
+		}
+		if tangents != nil {
+			v.Tangent = normalizeOrZero(linear.Mul3x1(mgl32.Vec3{tangents[4*i], tangents[4*i+1], tangents[4*i+2]}))
+			// w is the handedness of the tangent space
+			v.Bitangent = v.Normal.Cross(v.Tangent).Mul(tangents[4*i+3])
+		}
+		if texCoords != nil {
+			// glTF puts the origin of texture space at the top left, textures are uploaded
+			// bottom row first
+			v.TexCoords = mgl32.Vec2{texCoords[2*i], 1 - texCoords[2*i+1]}
+		}
+		if skinned {
+			sum := float32(0)
+			for j := 0; j < MaxBoneInfluence; j++ {
+				v.BoneIDs[j] = int32(joints[4*i+j])
+				v.Weights[j] = weights[4*i+j]
+				sum += v.Weights[j]
+			}
+			// quantized weights don't always add up to 1
+			if sum > 0 {
+				for j := range v.Weights {
+					v.Weights[j] /= sum
+				}
+			}
+		} else {
+			for j := range v.BoneIDs {
+				v.BoneIDs[j] = -1
+			}
+		}
+	}
+
+	var indices []uint32
+	if primitive.Indices != nil {
+		indices, err = l.readUints(*primitive.Indices)
+		if err != nil {
+			return Mesh{}, fmt.Errorf("indices: %w", err)
+		}
+		for _, index := range indices {
+			if int(index) >= len(vertices) {
+				return Mesh{}, fmt.Errorf("index %v is out of range for %v vertices", index, len(vertices))
+			}
+		}
+	} else {
+		indices = make([]uint32, len(vertices))
+		for i := range indices {
+			indices[i] = uint32(i)
+		}
+	}
+	indices = triangulate(indices, mode)
+	if len(indices) == 0 {
+		return Mesh{}, errors.New("primitive has no triangles")
+	}
+
+	if normals == nil {
+		// glTF says meshes without normals are flat shaded
+		vertices, indices = generateNormals(vertices, indices, l.model.options.smoothNormals(false), l.model.options.CreaseAngle)
+	}
+	// glTF wants MikkTSpace tangents when the file has none
+	vertices, indices = l.model.optimizeMesh(vertices, indices, tangents == nil && texCoords != nil)
+
+	material := defaultPBRMaterial()
+	var textures []Texture
+	if primitive.Material != nil {
+		if *primitive.Material < 0 || *primitive.Material >= len(l.doc.Materials) {
+			return Mesh{}, fmt.Errorf("material %v does not exist", *primitive.Material)
+		}
+		material, textures, err = l.loadMaterial(l.doc.Materials[*primitive.Material])
+		if err != nil {
+			return Mesh{}, fmt.Errorf("material %v: %w", *primitive.Material, err)
+		}
+	}
+
+	mesh := Mesh{
+		vertices: vertices,
+		indices:  indices,
+		material: material.material(textures),
+		pbr:      &material,
+	}
+	mesh.computeBounds()
+	mesh.lods = l.model.meshLODs(mesh.vertices, mesh.indices, mesh.sphere)
+	return mesh, nil
+}
+
+// triangulate turns strip and fan indices into a triangle list.
+func triangulate(indices []uint32, mode int) []uint32 {
+	switch mode {
+	case gltfTriangleStrip:
+		var list []uint32
+		for i := 2; i < len(indices); i++ {
+			// every other triangle is wound the other way round
+			if i%2 == 0 {
+				list = append(list, indices[i-2], indices[i-1], indices[i])
+			} else {
+				list = append(list, indices[i-1], indices[i-2], indices[i])
+			}
+		}
+		return list
+	case gltfTriangleFan:
+		var list []uint32
+		for i := 2; i < len(indices); i++ {
+			list = append(list, indices[0], indices[i-1], indices[i])
+		}
+		return list
+	}
+	return indices[:len(indices)/3*3]
+}
+
+func normalizeOrZero(v mgl32.Vec3) mgl32.Vec3 {
+	if v.Len() == 0 {
+		return v
+	}
+	return v.Normalize()
+}
+
+func (l *gltfLoader) loadMaterial(m gltfMaterial) (PBRMaterial, []Texture, error) {
+	material := defaultPBRMaterial()
+	material.Name = m.Name
+	material.DoubleSided = m.DoubleSided
+	if m.AlphaMode != "" {
+		material.AlphaMode = m.AlphaMode
+	}
+	if m.AlphaCutoff != nil {
+		material.AlphaCutoff = *m.AlphaCutoff
+	}
+	if len(m.EmissiveFactor) == 3 {
+		material.EmissiveFactor = mgl32.Vec3{m.EmissiveFactor[0], m.EmissiveFactor[1], m.EmissiveFactor[2]}
+	}
+
+	var textures []Texture
+	addTexture := func(info *gltfTextureInfo, texType string) error {
+		if info == nil {
+			return nil
+		}
+		if info.TexCoord != 0 {
+			return fmt.Errorf("%v uses TEXCOORD_%v, only TEXCOORD_0 is supported", texType, info.TexCoord)
+		}
+		texture, err := l.loadTexture(info.Index, texType)
+		if err != nil {
+			l.model.warn("%v: %v, using a placeholder", texType, err)
+			texture = Texture{ID: l.model.addTexture(TextureData{}), Type: texType}
+		}
+		textures = append(textures, texture)
+		return nil
+	}
+
+	if pbr := m.PbrMetallicRoughness; pbr != nil {
+		if len(pbr.BaseColorFactor) == 4 {
+			material.BaseColorFactor = mgl32.Vec4{pbr.BaseColorFactor[0], pbr.BaseColorFactor[1], pbr.BaseColorFactor[2], pbr.BaseColorFactor[3]}
+		}
+		if pbr.MetallicFactor != nil {
+			material.MetallicFactor = *pbr.MetallicFactor
+		}
+		if pbr.RoughnessFactor != nil {
+			material.RoughnessFactor = *pbr.RoughnessFactor
+		}
+		if err := addTexture(pbr.BaseColorTexture, "texture_diffuse"); err != nil {
+			return material, nil, err
+		}
+		if err := addTexture(pbr.MetallicRoughnessTexture, "texture_metallic_roughness"); err != nil {
+			return material, nil, err
+		}
+	}
+	if m.NormalTexture != nil {
+		if m.NormalTexture.Scale != 0 {
+			material.NormalScale = m.NormalTexture.Scale
+		}
+		if err := addTexture(m.NormalTexture, "texture_normal"); err != nil {
+			return material, nil, err
+		}
+	}
+	if m.OcclusionTexture != nil {
+		if m.OcclusionTexture.Strength != 0 {
+			material.OcclusionStrength = m.OcclusionTexture.Strength
+		}
+		if err := addTexture(m.OcclusionTexture, "texture_occlusion"); err != nil {
+			return material, nil, err
+		}
+	}
+	if err := addTexture(m.EmissiveTexture, "texture_emissive"); err != nil {
+		return material, nil, err
+	}
+	return material, textures, nil
+}
+
+// loadTexture reads the image of a glTF texture, once per image and type. It is decoded later
+// by decodeTextures.
+func (l *gltfLoader) loadTexture(index int, texType string) (Texture, error) {
+	if index < 0 || index >= len(l.doc.Textures) {
+		return Texture{}, fmt.Errorf("texture %v does not exist", index)
+	}
+	source := l.doc.Textures[index].Source
+	if source == nil || *source < 0 || *source >= len(l.doc.Images) {
+		return Texture{}, fmt.Errorf("texture %v has no image", index)
+	}
+	img := l.doc.Images[*source]
+
+	key := fmt.Sprintf("%v#image%v", l.path, *source)
+	if img.URI != "" && !strings.HasPrefix(img.URI, "data:") {
+		key = img.URI
+	}
+	if texture, ok := l.model.texturesLoaded[key]; ok {
+		texture.Type = texType
+		return texture, nil
+	}
+
+	var data []byte
+	var err error
+	switch {
+	case img.BufferView != nil:
+		data, err = l.viewBytes(*img.BufferView)
+	case img.URI != "":
+		data, err = l.readURI(img.URI)
+	default:
+		err = errors.New("image has no data")
+	}
+	if err != nil {
+		return Texture{}, fmt.Errorf("image %v: %w", *source, err)
+	}
+	// the image is decoded later, with the other images of the model
+	imageIndex := *source
+	decode := func() (TextureData, error) {
+		decoded, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return TextureData{}, fmt.Errorf("%v: image %v: %w", texType, imageIndex, err)
+		}
+		return imageTextureData(decoded), nil
+	}
+
+	texture := Texture{ID: l.model.addTextureDecode(decode), Type: texType, Path: key}
+	l.model.texturesLoaded[key] = texture
+	return texture, nil
+}
+
+// viewBytes returns the bytes of a buffer view.
+func (l *gltfLoader) viewBytes(index int) ([]byte, error) {
+	if index < 0 || index >= len(l.doc.BufferViews) {
+		return nil, fmt.Errorf("buffer view %v does not exist", index)
+	}
+	view := l.doc.BufferViews[index]
+	if view.Buffer < 0 || view.Buffer >= len(l.buffers) {
+		return nil, fmt.Errorf("buffer view %v: buffer %v does not exist", index, view.Buffer)
+	}
+	buffer := l.buffers[view.Buffer]
+	if view.ByteOffset < 0 || view.ByteLength < 0 || view.ByteOffset+view.ByteLength > len(buffer) {
+		return nil, fmt.Errorf("buffer view %v is outside of its buffer", index)
+	}
+	return buffer[view.ByteOffset : view.ByteOffset+view.ByteLength], nil
+}
+
+var gltfTypeComponents = map[string]int{
+	"SCALAR": 1,
+	"VEC2":   2,
+	"VEC3":   3,
+	"VEC4":   4,
+	"MAT4":   16,
+}
+
+var gltfComponentSizes = map[int]int{
+	5120: 1, // BYTE
+	5121: 1, // UNSIGNED_BYTE
+	5122: 2, // SHORT
+	5123: 2, // UNSIGNED_SHORT
+	5125: 4, // UNSIGNED_INT
+	5126: 4, // FLOAT
+}
+
+// accessorElements calls read for every component of every element of an accessor, with the
+// raw bytes of the component.
+func (l *gltfLoader) accessorElements(index int, read func(component []byte)) (accessor gltfAccessor, err error) {
+	if index < 0 || index >= len(l.doc.Accessors) {
+		return accessor, fmt.Errorf("accessor %v does not exist", index)
+	}
+	accessor = l.doc.Accessors[index]
+	if len(accessor.Sparse) > 0 {
+		return accessor, fmt.Errorf("accessor %v: sparse accessors are not supported", index)
+	}
+	components, ok := gltfTypeComponents[accessor.Type]
+	if !ok {
+		return accessor, fmt.Errorf("accessor %v: type %v is not supported", index, accessor.Type)
+	}
+	size, ok := gltfComponentSizes[accessor.ComponentType]
+	if !ok {
+		return accessor, fmt.Errorf("accessor %v: component type %v is not supported", index, accessor.ComponentType)
+	}
+	if accessor.BufferView == nil {
+		// no buffer view means all zeros
+		zero := make([]byte, size)
+		for i := 0; i < accessor.Count*components; i++ {
+			read(zero)
+		}
+		return accessor, nil
+	}
+
+	data, err := l.viewBytes(*accessor.BufferView)
+	if err != nil {
+		return accessor, fmt.Errorf("accessor %v: %w", index, err)
+	}
+	stride := l.doc.BufferViews[*accessor.BufferView].ByteStride
+	if stride == 0 {
+		stride = size * components
+	}
+	end := accessor.ByteOffset + (accessor.Count-1)*stride + size*components
+	if accessor.Count > 0 && (accessor.ByteOffset < 0 || end > len(data)) {
+		return accessor, fmt.Errorf("accessor %v is outside of its buffer view", index)
+	}
+	for i := 0; i < accessor.Count; i++ {
+		element := accessor.ByteOffset + i*stride
+		for c := 0; c < components; c++ {
+			read(data[element+c*size : element+(c+1)*size])
+		}
+	}
+	return accessor, nil
+}
+
+// readFloats reads an accessor with the given number of components per element as floats.
+// Integer components are converted, and mapped to [0, 1] or [-1, 1] if normalized.
+func (l *gltfLoader) readFloats(index, components int) (values []float32, count int, err error) {
+	var accessor gltfAccessor
+	if index >= 0 && index < len(l.doc.Accessors) {
+		accessor = l.doc.Accessors[index]
+		if gltfTypeComponents[accessor.Type] != components {
+			return nil, 0, fmt.Errorf("accessor %v is a %v, expected %v components", index, accessor.Type, components)
+		}
+	}
+	_, err = l.accessorElements(index, func(b []byte) {
+		var v float32
+		switch accessor.ComponentType {
+		case 5126:
+			v = math.Float32frombits(binary.LittleEndian.Uint32(b))
+		case 5120:
+			v = float32(int8(b[0]))
+			if accessor.Normalized {
+				v = max(v/127, -1)
+			}
+		case 5121:
+			v = float32(b[0])
+			if accessor.Normalized {
+				v /= 255
+			}
+		case 5122:
+			v = float32(int16(binary.LittleEndian.Uint16(b)))
+			if accessor.Normalized {
+				v = max(v/32767, -1)
+			}
+		case 5123:
+			v = float32(binary.LittleEndian.Uint16(b))
+			if accessor.Normalized {
+				v /= 65535
+			}
+		case 5125:
+			v = float32(binary.LittleEndian.Uint32(b))
+		}
+		values = append(values, v)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return values, accessor.Count, nil
+}
+
+// readUints reads an accessor of unsigned integers, like indices or joints.
+func (l *gltfLoader) readUints(index int) ([]uint32, error) {
+	if index >= 0 && index < len(l.doc.Accessors) {
+		switch componentType := l.doc.Accessors[index].ComponentType; componentType {
+		case 5121, 5123, 5125:
+		default:
+			return nil, fmt.Errorf("accessor %v: component type %v is not an unsigned integer", index, componentType)
+		}
+	}
+	var values []uint32
+	_, err := l.accessorElements(index, func(b []byte) {
+		switch len(b) {
+		case 1:
+			values = append(values, uint32(b[0]))
+		case 2:
+			values = append(values, uint32(binary.LittleEndian.Uint16(b)))
+		case 4:
+			values = append(values, binary.LittleEndian.Uint32(b))
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
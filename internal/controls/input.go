@@ -0,0 +1,286 @@
+// Package controls maps named input actions to keys, mouse buttons and gamepads. It is shared
+// by the app and breakout.
+package controls
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/go-gl/glfw/v3.3/glfw"
+)
+
+// Input maps named actions like "move_left" to keys, mouse buttons and gamepad buttons and
+// axes. Call Update once per frame, then ask about actions with Pressed, Held, Released and
+// Value.
+//
+// Bindings are written as strings:
+//
+//	key:W, key:Up, key:Space, key:LeftShift, key:F5
+//	mouse:left, mouse:right, mouse:middle
+//	gamepad:a, gamepad:start, gamepad:dpad_up
+//	axis:left_x+, axis:left_y-, axis:right_trigger+
+//
+// An axis binding is active when the stick is pushed far enough in the direction of its sign.
+type Input struct {
+	bindings  map[string][]binding
+	active    map[string]bool
+	wasActive map[string]bool
+	values    map[string]float32
+}
+
+type bindingKind int
+
+const (
+	keyBinding bindingKind = iota
+	mouseBinding
+	gamepadButtonBinding
+	gamepadAxisBinding
+)
+
+type binding struct {
+	kind   bindingKind
+	code   int
+	sign   float32
+	source string
+}
+
+// Sticks inside the dead zone count as centered. Past it they are held, with a value that
+// grows with how far they're pushed.
+const axisDeadZone = 0.15
+
+// NewInput creates an input mapping with default bindings, see Input for their format.
+func NewInput(defaults map[string][]string) *Input {
+	in := &Input{
+		bindings:  make(map[string][]binding),
+		active:    make(map[string]bool),
+		wasActive: make(map[string]bool),
+		values:    make(map[string]float32),
+	}
+	for action, bindings := range defaults {
+		if err := in.bind(action, bindings...); err != nil {
+			panic(err)
+		}
+	}
+	return in
+}
+
+// bind replaces the bindings of an action.
+func (in *Input) bind(action string, bindings ...string) error {
+	parsed := make([]binding, 0, len(bindings))
+	for _, source := range bindings {
+		b, err := parseBinding(source)
+		if err != nil {
+			return fmt.Errorf("action %q: %w", action, err)
+		}
+		parsed = append(parsed, b)
+	}
+	in.bindings[action] = parsed
+	return nil
+}
+
+// LoadBindings reads a JSON file of action names to lists of bindings, like
+//
+//	{"move_left": ["key:A", "key:Left", "axis:left_x-"]}
+//
+// Actions in the file replace the defaults, the rest keep them. A missing file is not an error.
+func (in *Input) LoadBindings(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var config map[string][]string
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("%v: %w", path, err)
+	}
+	for action, bindings := range config {
+		if err := in.bind(action, bindings...); err != nil {
+			return fmt.Errorf("%v: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// Update polls every binding. Call it once per frame after glfw.PollEvents.
+func (in *Input) Update(w *glfw.Window) {
+	gamepad := firstGamepad()
+	for action, bindings := range in.bindings {
+		in.wasActive[action] = in.active[action]
+		value := float32(0)
+		for _, b := range bindings {
+			value = max(value, b.value(w, gamepad))
+		}
+		in.values[action] = value
+		in.active[action] = value > 0
+	}
+}
+
+// Pressed reports whether the action started this frame.
+func (in *Input) Pressed(action string) bool {
+	return in.active[action] && !in.wasActive[action]
+}
+
+// Held reports whether the action is active.
+func (in *Input) Held(action string) bool {
+	return in.active[action]
+}
+
+// Released reports whether the action stopped this frame.
+func (in *Input) Released(action string) bool {
+	return !in.active[action] && in.wasActive[action]
+}
+
+// Value is how strongly the action is active, from 0 to 1. Keys and buttons are 0 or 1,
+// sticks and triggers anything in between.
+func (in *Input) Value(action string) float32 {
+	return in.values[action]
+}
+
+func (b binding) value(w *glfw.Window, gamepad *glfw.GamepadState) float32 {
+	switch b.kind {
+	case keyBinding:
+		if w.GetKey(glfw.Key(b.code)) == glfw.Press {
+			return 1
+		}
+	case mouseBinding:
+		if w.GetMouseButton(glfw.MouseButton(b.code)) == glfw.Press {
+			return 1
+		}
+	case gamepadButtonBinding:
+		if gamepad != nil && gamepad.Buttons[b.code] == glfw.Press {
+			return 1
+		}
+	case gamepadAxisBinding:
+		if gamepad == nil {
+			return 0
+		}
+		axis := gamepad.Axes[b.code] * b.sign
+		if axis <= axisDeadZone {
+			return 0
+		}
+		// rescale so the value starts at 0 at the edge of the dead zone
+		return float32(math.Min(1, float64((axis-axisDeadZone)/(1-axisDeadZone))))
+	}
+	return 0
+}
+
+// firstGamepad returns the state of the first connected gamepad, or nil if there is none.
+func firstGamepad() *glfw.GamepadState {
+	for joystick := glfw.Joystick1; joystick <= glfw.JoystickLast; joystick++ {
+		if joystick.IsGamepad() {
+			return joystick.GetGamepadState()
+		}
+	}
+	return nil
+}
+
+func parseBinding(source string) (binding, error) {
+	device, name, ok := strings.Cut(source, ":")
+	if !ok {
+		return binding{}, fmt.Errorf("binding %q is missing a device, like key:W", source)
+	}
+	b := binding{source: source}
+	var found bool
+	switch strings.ToLower(device) {
+	case "key":
+		b.kind = keyBinding
+		b.code, found = keyNames[strings.ToLower(name)]
+	case "mouse":
+		b.kind = mouseBinding
+		b.code, found = mouseButtonNames[strings.ToLower(name)]
+	case "gamepad":
+		b.kind = gamepadButtonBinding
+		b.code, found = gamepadButtonNames[strings.ToLower(name)]
+	case "axis":
+		b.kind = gamepadAxisBinding
+		b.sign = 1
+		if strings.HasSuffix(name, "-") {
+			b.sign = -1
+		}
+		b.code, found = gamepadAxisNames[strings.ToLower(strings.TrimRight(name, "+-"))]
+	default:
+		return binding{}, fmt.Errorf("binding %q has unknown device %q", source, device)
+	}
+	if !found {
+		return binding{}, fmt.Errorf("binding %q: unknown %v %q", source, device, name)
+	}
+	return b, nil
+}
+
+// keyNames maps lower case key names to glfw key codes.
+var keyNames = makeKeyNames()
+
+func makeKeyNames() map[string]int {
+	names := map[string]int{
+		"space":        int(glfw.KeySpace),
+		"enter":        int(glfw.KeyEnter),
+		"escape":       int(glfw.KeyEscape),
+		"tab":          int(glfw.KeyTab),
+		"backspace":    int(glfw.KeyBackspace),
+		"up":           int(glfw.KeyUp),
+		"down":         int(glfw.KeyDown),
+		"left":         int(glfw.KeyLeft),
+		"right":        int(glfw.KeyRight),
+		"pageup":       int(glfw.KeyPageUp),
+		"pagedown":     int(glfw.KeyPageDown),
+		"home":         int(glfw.KeyHome),
+		"end":          int(glfw.KeyEnd),
+		"leftshift":    int(glfw.KeyLeftShift),
+		"rightshift":   int(glfw.KeyRightShift),
+		"leftcontrol":  int(glfw.KeyLeftControl),
+		"rightcontrol": int(glfw.KeyRightControl),
+		"leftalt":      int(glfw.KeyLeftAlt),
+		"rightalt":     int(glfw.KeyRightAlt),
+	}
+	// letters, digits and function keys have consecutive codes
+	for i := 0; i < 26; i++ {
+		names[string(rune('a'+i))] = int(glfw.KeyA) + i
+	}
+	for i := 0; i < 10; i++ {
+		names[string(rune('0'+i))] = int(glfw.Key0) + i
+	}
+	for i := 0; i < 12; i++ {
+		names[fmt.Sprintf("f%v", i+1)] = int(glfw.KeyF1) + i
+	}
+	return names
+}
+
+var mouseButtonNames = map[string]int{
+	"left":   int(glfw.MouseButtonLeft),
+	"right":  int(glfw.MouseButtonRight),
+	"middle": int(glfw.MouseButtonMiddle),
+}
+
+var gamepadButtonNames = map[string]int{
+	"a":            int(glfw.ButtonA),
+	"b":            int(glfw.ButtonB),
+	"x":            int(glfw.ButtonX),
+	"y":            int(glfw.ButtonY),
+	"left_bumper":  int(glfw.ButtonLeftBumper),
+	"right_bumper": int(glfw.ButtonRightBumper),
+	"back":         int(glfw.ButtonBack),
+	"start":        int(glfw.ButtonStart),
+	"guide":        int(glfw.ButtonGuide),
+	"left_thumb":   int(glfw.ButtonLeftThumb),
+	"right_thumb":  int(glfw.ButtonRightThumb),
+	"dpad_up":      int(glfw.ButtonDpadUp),
+	"dpad_right":   int(glfw.ButtonDpadRight),
+	"dpad_down":    int(glfw.ButtonDpadDown),
+	"dpad_left":    int(glfw.ButtonDpadLeft),
+}
+
+var gamepadAxisNames = map[string]int{
+	"left_x":        int(glfw.AxisLeftX),
+	"left_y":        int(glfw.AxisLeftY),
+	"right_x":       int(glfw.AxisRightX),
+	"right_y":       int(glfw.AxisRightY),
+	"left_trigger":  int(glfw.AxisLeftTrigger),
+	"right_trigger": int(glfw.AxisRightTrigger),
+}
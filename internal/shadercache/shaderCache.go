@@ -0,0 +1,117 @@
+// Package shadercache caches linked shader programs on disk as driver specific binaries, so
+// programs that were linked before skip compiling. It is shared by the app and breakout.
+package shadercache
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// Dir is where linked programs are cached. Set it to an empty string to always build programs
+// from source.
+var Dir = defaultDir()
+
+func defaultDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "learn-opengl", "shaders")
+}
+
+// Supported reports whether the driver can hand out program binaries at all. Some, like
+// macOS, support the calls but no binary formats.
+func Supported() bool {
+	var formats int32
+	gl.GetIntegerv(gl.NUM_PROGRAM_BINARY_FORMATS, &formats)
+	return formats > 0
+}
+
+// Stage is a shader stage of a program, as far as the cache is concerned.
+type Stage struct {
+	// Kind is the shader type, like gl.VERTEX_SHADER
+	Kind uint32
+	// Source is the preprocessed source
+	Source string
+}
+
+// Key hashes everything a linked program binary depends on: the preprocessed source of every
+// stage and the driver that built it.
+func Key(stages []Stage) string {
+	h := sha256.New()
+	for _, name := range []uint32{gl.VENDOR, gl.RENDERER, gl.VERSION} {
+		h.Write([]byte(gl.GoStr(gl.GetString(name))))
+		h.Write([]byte{0})
+	}
+	for _, stage := range stages {
+		binary.Write(h, binary.LittleEndian, stage.Kind)
+		h.Write([]byte(stage.Source))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Load creates a program from a cached binary. It fails if there is no binary for key or the
+// driver rejects it, for example after a driver update.
+func Load(key string) (uint32, bool) {
+	path := filepath.Join(Dir, key+".bin")
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) <= 4 {
+		return 0, false
+	}
+	// the binary format comes first, the binary itself follows
+	format := binary.LittleEndian.Uint32(data)
+	program := data[4:]
+
+	ID := gl.CreateProgram()
+	gl.ProgramBinary(ID, format, gl.Ptr(program), int32(len(program)))
+	var success int32
+	gl.GetProgramiv(ID, gl.LINK_STATUS, &success)
+	if success == gl.FALSE {
+		gl.DeleteProgram(ID)
+		os.Remove(path)
+		return 0, false
+	}
+	return ID, true
+}
+
+// Store writes the binary of a linked program to the cache.
+func Store(ID uint32, key string) {
+	var length int32
+	gl.GetProgramiv(ID, gl.PROGRAM_BINARY_LENGTH, &length)
+	if length == 0 {
+		return
+	}
+	data := make([]byte, 4+length)
+	var format uint32
+	gl.GetProgramBinary(ID, length, nil, &format, gl.Ptr(data[4:]))
+	binary.LittleEndian.PutUint32(data, format)
+
+	if err := os.MkdirAll(Dir, 0o755); err != nil {
+		log.Printf("failed to create shader cache: %v", err)
+		return
+	}
+	// write to a temporary file first so a crash never leaves half a binary behind
+	tmp, err := os.CreateTemp(Dir, key+"-*.tmp")
+	if err != nil {
+		log.Printf("failed to cache shader program: %v", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(Dir, key+".bin"))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Printf("failed to cache shader program: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// lodPixelError is how many pixels a level of detail may be off the full mesh on screen
+// before Model.Draw picks a finer one.
+const lodPixelError = 1.0
+
+// meshLOD is a simpler version of a mesh. It only has indices, the vertices are the mesh's.
+type meshLOD struct {
+	indices []uint32
+	// error is how far the level is from the full mesh, relative to the radius of its
+	// bounding sphere
+	error float32
+	// offset is where the indices start in the EBO of the mesh, in indices
+	offset int
+}
+
+// meshLODs builds the levels of detail the model options ask for, each simplified from the one
+// before it. Levels stop when the mesh can't get any simpler.
+func (m *Model) meshLODs(vertices []Vertex, indices []uint32, sphere BoundingSphere) []meshLOD {
+	if len(m.options.LODRatios) == 0 {
+		return nil
+	}
+	var lods []meshLOD
+	s := newSimplifier(vertices, indices)
+	triangles := len(indices) / 3
+	previous := triangles
+	for _, ratio := range m.options.LODRatios {
+		// a level without triangles would make the mesh vanish in the distance
+		s.simplify(max(1, int(float32(triangles)*ratio)))
+		if len(s.indices) == 0 || len(s.indices)/3 >= previous {
+			break
+		}
+		previous = len(s.indices) / 3
+
+		lod := meshLOD{indices: append([]uint32(nil), s.indices...)}
+		if sphere.Radius > 0 {
+			lod.error = s.distance() / sphere.Radius
+		}
+		if m.options.OptimizeVertexCache {
+			lod.indices = optimizeVertexCache(lod.indices, len(vertices))
+		}
+		lods = append(lods, lod)
+		m.stats.LODTriangles += len(lod.indices) / 3
+	}
+	return lods
+}
+
+// LODView is where a model is seen from, for Model.Draw to pick the levels of detail of its
+// meshes. See Camera.lodView.
+type LODView struct {
+	// Eye is the camera position in the local space of the model
+	Eye mgl32.Vec3
+	// PixelsPerUnit is how many pixels something one unit long covers one unit in front of
+	// the camera, or at any distance for orthographic cameras
+	PixelsPerUnit float32
+	Orthographic  bool
+}
+
+// projectedRadius returns how many pixels the radius of the sphere covers on screen.
+func (v *LODView) projectedRadius(sphere BoundingSphere) float32 {
+	if v.Orthographic {
+		return sphere.Radius * v.PixelsPerUnit
+	}
+	distance := sphere.Center.Sub(v.Eye).Len()
+	if distance <= sphere.Radius {
+		// the camera is inside it
+		return math.MaxFloat32
+	}
+	return sphere.Radius / distance * v.PixelsPerUnit
+}
+
+// selectLOD returns the coarsest level of detail that doesn't look more than lodPixelError
+// pixels off from the view, 0 for the full mesh. A nil view always gets the full mesh.
+func (mesh *Mesh) selectLOD(view *LODView) int {
+	if view == nil || len(mesh.lods) == 0 {
+		return 0
+	}
+	radius := view.projectedRadius(mesh.sphere)
+	level := 0
+	for i, lod := range mesh.lods {
+		if lod.error*radius > lodPixelError {
+			break
+		}
+		level = i + 1
+	}
+	return level
+}
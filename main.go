@@ -9,6 +9,7 @@ import (
 	"math"
 	"os"
 	"runtime"
+	"time"
 	"unsafe"
 
 	_ "github.com/mdouchement/hdr/codec/rgbe"
@@ -19,12 +20,22 @@ import (
 	"github.com/go-gl/gl/v4.1-core/gl"
 	"github.com/go-gl/glfw/v3.3/glfw"
 	"github.com/go-gl/mathgl/mgl32"
+
+	"github.com/braheezy/learn-opengl/internal/controls"
 )
 
 // Settings
 const (
 	windowWidth  = 800
 	windowHeight = 600
+	// where F5 and F9 save and load the camera path
+	cameraPathFile = "camera_path.json"
+	// where camera bookmarks and the last camera state are kept between runs
+	cameraSessionFile = "camera_bookmarks.json"
+	// bindings that replace the defaults in defaultBindings
+	inputConfigFile = "input.json"
+	// how long each frame may spend uploading assets loaded in the background
+	uploadBudget = 2 * time.Millisecond
 )
 
 // Character represents a glyph's texture and related data.
@@ -46,7 +57,21 @@ var (
 	// Handle when mouse first enters window and has large offset to center
 	firstMouse = true
 	camera     *Camera
+	// where the camera starts, and goes back to on Backspace
+	defaultCameraPosition = mgl32.Vec3{0.0, 0.0, 5.0}
+	cameraSession         *CameraSession
+	// flightCamera takes over from camera while flying, nil otherwise
+	flightCamera *FlightCamera
+	input        = controls.NewInput(defaultBindings)
+	// recorded camera flight, see keyCallback
+	cameraPath       = &CameraPath{}
+	cameraPathPlayer = NewCameraPathPlayer(cameraPath)
+	// textures and models loading in the background
+	assets     = NewAssetLoader(0)
 	Characters map[rune]Character
+	// the model given on the command line, if any, framed once it's ready
+	sceneModel  *ModelHandle
+	sceneFramed bool
 
 	VAO, VBO uint32
 )
@@ -55,16 +80,31 @@ func init() {
 	// This is needed to arrange that main() runs on main thread.
 	runtime.LockOSThread()
 
-	camera = NewDefaultCameraAtPosition(mgl32.Vec3{0.0, 0.0, 5.0})
+	camera = NewDefaultCameraAtPosition(defaultCameraPosition)
 }
 
 func main() {
+	// Put the camera back where it was last time
+	session, err := LoadCameraSession(cameraSessionFile)
+	if err != nil {
+		log.Printf("failed to load camera bookmarks: %v", err)
+	}
+	cameraSession = session
+	if session.Last != nil {
+		camera.setState(*session.Last)
+	}
+	defer saveCameraSession()
+
+	if err := input.LoadBindings(inputConfigFile); err != nil {
+		log.Printf("failed to load input bindings: %v", err)
+	}
+
 	/*
 	 * GLFW init and configure
 	 */
 	// Initialize GLFW, which is used to manage windows, user input, opengl contexts, and related
 	// events.
-	err := glfw.Init()
+	err = glfw.Init()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -97,6 +137,8 @@ func main() {
 	// window.SetInputMode(glfw.CursorMode, glfw.CursorDisabled)
 	// Listen to scroll events
 	window.SetScrollCallback(scrollCallback)
+	// Listen to key presses that toggle things
+	window.SetKeyCallback(keyCallback)
 
 	/*
 	 * Load OS-specific OpenGL function pointers
@@ -111,6 +153,9 @@ func main() {
 	gl.Enable(gl.BLEND)
 	gl.BlendFunc(gl.SRC_ALPHA, gl.ONE_MINUS_SRC_ALPHA)
 
+	// Camera data shared by every shader declaring the Camera uniform block
+	cameraUBO := NewUniformBuffer("Camera", CameraBlock{})
+
 	/*
 	 * Build and compile our shader program
 	 */
@@ -118,6 +163,19 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	// recompile when the shader files are edited
+	shader.watch()
+
+	// Load the model named on the command line in the background
+	modelShader, err := NewShader("shaders/model.vs", "shaders/model.fs", "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	modelShader.watch()
+	if len(os.Args) > 1 {
+		// with simpler versions of the meshes for when the model is far away
+		sceneModel = assets.LoadModel(os.Args[1], ModelOptions{LODRatios: []float32{0.5, 0.25, 0.1}})
+	}
 
 	// shader configuration
 	// --------------------
@@ -244,10 +302,28 @@ func main() {
 
 		// Handle user input.
 		processInput(window)
+		camera.update(float32(deltaTime))
+
+		// Fly along the camera path while it plays
+		if cameraPathPlayer.playing {
+			cameraPathPlayer.advance(float32(deltaTime))
+			cameraPathPlayer.apply(camera)
+		}
+
+		// Pick up edited shaders
+		reloadChangedShaders()
+
+		// Hand finished background loads to OpenGL
+		assets.processUploads(uploadBudget)
+
+		// Upload this frame's camera once for all shaders
+		cameraUBO.update(activeCamera().block())
 
 		gl.ClearColor(0.1, 0.1, 0.1, 1.0)
 		gl.Clear(gl.COLOR_BUFFER_BIT | gl.DEPTH_BUFFER_BIT)
 
+		drawScene(window, modelShader)
+
 		renderText(shader, "This is sample text", 25.0, 25.0, 1.0, mgl32.Vec3{0.5, 0.8, 0.2})
 		renderText(shader, "Learn OpenGL in Go!", 540.0, 570.0, 0.5, mgl32.Vec3{0.3, 0.7, 0.9})
 
@@ -262,7 +338,7 @@ func main() {
 func renderText(shader *Shader, text string, x, y, scale float32, color mgl32.Vec3) {
 	// Activate corresponding render state
 	shader.use()
-	gl.Uniform3f(gl.GetUniformLocation(shader.id, gl.Str("textColor\x00")), color.X(), color.Y(), color.Z())
+	shader.setVec3("textColor", color)
 	gl.ActiveTexture(gl.TEXTURE0)
 	gl.BindVertexArray(VAO)
 
@@ -316,6 +392,10 @@ func renderText(shader *Shader, text string, x, y, scale float32, color mgl32.Ve
 // framebufferSizeCallback is called when the gl viewport is resized.
 func framebufferSizeCallback(w *glfw.Window, width int, height int) {
 	gl.Viewport(0, 0, int32(width), int32(height))
+	camera.setAspect(width, height)
+	if flightCamera != nil {
+		flightCamera.setAspect(width, height)
+	}
 }
 
 // mouseCallback is called every time the mouse is moved. x, y are current positions of the mouse
@@ -332,47 +412,266 @@ func mouseCallback(w *glfw.Window, x float64, y float64) {
 	lastX = x
 	lastY = y
 
-	camera.processMouseMovement(float32(xOffset), float32(yOffset), true)
+	switch {
+	case flightCamera != nil:
+		flightCamera.processMouseMovement(float32(xOffset), float32(yOffset))
+	case input.Held("pan"):
+		camera.processMousePan(float32(xOffset), float32(yOffset))
+	case camera.mode == OrbitMode && !input.Held("orbit"):
+		// only orbit while dragging
+	default:
+		camera.processMouseMovement(float32(xOffset), float32(yOffset), true)
+	}
+}
+
+// defaultBindings are the input actions of the app and what triggers them.
+var defaultBindings = map[string][]string{
+	"quit":              {"key:Escape", "gamepad:back"},
+	"move_forward":      {"key:W", "axis:left_y-"},
+	"move_backward":     {"key:S", "axis:left_y+"},
+	"move_left":         {"key:A", "axis:left_x-"},
+	"move_right":        {"key:D", "axis:left_x+"},
+	"move_up":           {"key:Space", "gamepad:right_bumper"},
+	"move_down":         {"key:C", "gamepad:left_bumper"},
+	"sprint":            {"key:LeftAlt", "gamepad:left_thumb"},
+	"slow":              {"key:Z"},
+	"capture_cursor":    {"key:LeftShift"},
+	"release_cursor":    {"key:RightShift"},
+	"reset_view":        {"key:Backspace", "gamepad:start"},
+	"pan":               {"mouse:middle"},
+	"orbit":             {"mouse:left"},
+	"toggle_orbit":      {"key:Tab"},
+	"toggle_projection": {"key:P"},
+	"toggle_smooth":     {"key:M"},
+	"toggle_scroll":     {"key:X"},
+	"record_keyframe":   {"key:K"},
+	"play_path":         {"key:L"},
+	"save_path":         {"key:F5"},
+	"load_path":         {"key:F9"},
+	"frame_model":       {"key:F"},
+	"toggle_flight":     {"key:G"},
+	"pick":              {"mouse:right"},
+	"roll_left":         {"key:Q"},
+	"roll_right":        {"key:E"},
+}
+
+var cameraMovementActions = map[string]CameraMovement{
+	"move_forward":  FORWARD,
+	"move_backward": BACKWARD,
+	"move_left":     LEFT,
+	"move_right":    RIGHT,
+	"move_up":       UP,
+	"move_down":     DOWN,
+}
+
+// drawScene draws the model from the command line once it has loaded, framing it the first
+// time. Its meshes are drawn at the level of detail that fits their size on screen.
+func drawScene(w *glfw.Window, shader *Shader) {
+	if sceneModel == nil {
+		return
+	}
+	switch sceneModel.Status() {
+	case AssetFailed:
+		log.Printf("failed to load model: %v", sceneModel.Err())
+		sceneModel = nil
+		return
+	case AssetLoading:
+		return
+	}
+	model := sceneModel.Model()
+	if !sceneFramed {
+		camera.frameBounds(model.bounds())
+		sceneFramed = true
+	}
+
+	gl.Enable(gl.DEPTH_TEST)
+	shader.use()
+	shader.setMat4("model", mgl32.Ident4())
+	block := activeCamera().block()
+	frustum := extractFrustum(block.Projection.Mul4(block.View))
+	_, height := w.GetFramebufferSize()
+	view := activeCamera().lodView(mgl32.Ident4(), height)
+	model.Draw(*shader, &frustum, &view)
+	// text is drawn on top
+	gl.Disable(gl.DEPTH_TEST)
+}
+
+// pick prints the triangle of the scene model under the cursor.
+func pick(w *glfw.Window) {
+	if sceneModel == nil || sceneModel.Model() == nil {
+		return
+	}
+	x, y := w.GetCursorPos()
+	width, height := w.GetSize()
+	ray := activeCamera().cursorRay(float32(x), float32(y), float32(width), float32(height))
+	// the model is drawn without a transform, so world space is model space
+	hit, ok := sceneModel.Model().Raycast(ray)
+	if !ok {
+		fmt.Println("picked nothing")
+		return
+	}
+	fmt.Printf("picked mesh %v triangle %v at %v, %.2f away\n", hit.Mesh, hit.Triangle, hit.Point, hit.Distance)
+}
+
+// activeCamera returns the camera the scene is drawn from.
+func activeCamera() viewer {
+	if flightCamera != nil {
+		return flightCamera
+	}
+	return camera
+}
+
+// toggleFlight switches between camera and a flight camera starting where it is. Leaving
+// flight puts camera where the flight ended, without the roll.
+func toggleFlight() {
+	if flightCamera == nil {
+		flightCamera = NewFlightCameraFromCamera(camera)
+		fmt.Println("flight camera: on")
+		return
+	}
+	camera.position = flightCamera.position
+	camera.setOrientation(flightCamera.orientation)
+	flightCamera = nil
+	fmt.Println("flight camera: off")
+}
+
+// saveCameraSession writes the bookmarks and the current camera state to disk.
+func saveCameraSession() {
+	state := camera.state()
+	cameraSession.Last = &state
+	if err := cameraSession.Save(cameraSessionFile); err != nil {
+		log.Printf("failed to save camera bookmarks: %v", err)
+	}
+}
+
+// keyCallback is called once for every key press. Bookmarks are handled here rather than as
+// actions because they depend on the modifier keys.
+func keyCallback(w *glfw.Window, key glfw.Key, scancode int, action glfw.Action, mods glfw.ModifierKey) {
+	if action != glfw.Press {
+		return
+	}
+
+	// camera bookmarks: number keys jump to a bookmark, Ctrl+number stores one
+	if key >= glfw.Key1 && key <= glfw.Key9 {
+		name := string(rune('0' + key - glfw.Key0))
+		if mods&glfw.ModControl != 0 {
+			cameraSession.Bookmarks[name] = camera.state()
+			saveCameraSession()
+			fmt.Printf("stored camera bookmark %v\n", name)
+		} else if state, ok := cameraSession.Bookmarks[name]; ok {
+			camera.setState(state)
+		}
+	}
 }
 
 // scrollCallback is called every time the mouse scroll is moved. The offset values are how far the wheel has moved
 func scrollCallback(w *glfw.Window, xOffset float64, yOffset float64) {
+	if flightCamera != nil {
+		flightCamera.processMouseScroll(float32(yOffset))
+		return
+	}
 	camera.processMouseScroll(float32(yOffset))
 }
 
-// processInput handles key presses.
+// processInput handles the input actions of this frame.
 func processInput(w *glfw.Window) {
-	if w.GetKey(glfw.KeyEscape) == glfw.Press {
+	input.Update(w)
+
+	if input.Pressed("quit") {
 		w.SetShouldClose(true)
 	}
 
-	if w.GetKey(glfw.KeyW) == glfw.Press {
-		camera.processKeyboard(FORWARD, float32(deltaTime))
-	}
-	if w.GetKey(glfw.KeyS) == glfw.Press {
-		camera.processKeyboard(BACKWARD, float32(deltaTime))
+	if input.Pressed("toggle_flight") {
+		toggleFlight()
 	}
-	if w.GetKey(glfw.KeyA) == glfw.Press {
-		camera.processKeyboard(LEFT, float32(deltaTime))
+	camera.setSpeedModifiers(input.Held("sprint"), input.Held("slow"))
+	// scale by the action value so sticks move slower when they're only pushed a little
+	for action, direction := range cameraMovementActions {
+		if !input.Held(action) {
+			continue
+		}
+		if flightCamera != nil {
+			flightCamera.processKeyboard(direction, float32(deltaTime)*input.Value(action))
+		} else {
+			camera.processKeyboard(direction, float32(deltaTime)*input.Value(action))
+		}
 	}
-	if w.GetKey(glfw.KeyD) == glfw.Press {
-		camera.processKeyboard(RIGHT, float32(deltaTime))
+	if flightCamera != nil {
+		if input.Held("roll_left") {
+			flightCamera.processRoll(-1, float32(deltaTime)*input.Value("roll_left"))
+		}
+		if input.Held("roll_right") {
+			flightCamera.processRoll(1, float32(deltaTime)*input.Value("roll_right"))
+		}
 	}
 
-	if w.GetKey(glfw.KeyLeftShift) == glfw.Press {
+	if input.Pressed("capture_cursor") {
 		// Tell glfw to capture and hide the cursor
 		w.SetInputMode(glfw.CursorMode, glfw.CursorDisabled)
 	}
-	if w.GetKey(glfw.KeyRightShift) == glfw.Press {
+	if input.Pressed("release_cursor") {
 		// Tell glfw to show and stop capturing cursor
 		w.SetInputMode(glfw.CursorMode, glfw.CursorNormal)
 	}
-	if w.GetKey(glfw.KeyBackspace) == glfw.Press {
-		// reset view
-		camera = NewDefaultCameraAtPosition(mgl32.Vec3{1.0, 0.5, 4.0})
+	if input.Pressed("reset_view") {
+		// reset view, keeping the projection that follows the window size
+		camera.setState(NewDefaultCameraAtPosition(defaultCameraPosition).state())
+	}
+
+	if input.Pressed("toggle_orbit") {
+		camera.toggleMode()
+		fmt.Printf("camera mode: %v\n", camera.mode)
+	}
+	if input.Pressed("toggle_projection") {
+		camera.orthographic = !camera.orthographic
+	}
+	if input.Pressed("frame_model") && sceneModel != nil && sceneModel.Model() != nil {
+		camera.frameBounds(sceneModel.Model().bounds())
+	}
+	if input.Pressed("pick") {
+		pick(w)
+	}
+	if input.Pressed("toggle_smooth") {
+		camera.smooth = !camera.smooth
+		fmt.Printf("smooth camera: %v\n", camera.smooth)
+	}
+	if input.Pressed("toggle_scroll") {
+		camera.scrollAdjustsSpeed = !camera.scrollAdjustsSpeed
+		fmt.Printf("scroll adjusts speed: %v\n", camera.scrollAdjustsSpeed)
 	}
 
+	// camera paths
+	if input.Pressed("record_keyframe") {
+		// keyframes are one second apart
+		t := float32(0)
+		if len(cameraPath.Keyframes) > 0 {
+			t = cameraPath.duration() + 1
+		}
+		cameraPath.record(camera, t)
+		fmt.Printf("recorded camera keyframe %v at %vs\n", len(cameraPath.Keyframes), t)
+	}
+	if input.Pressed("play_path") {
+		if !cameraPathPlayer.playing && cameraPathPlayer.time >= cameraPath.duration() {
+			cameraPathPlayer.seek(0)
+		}
+		cameraPathPlayer.playing = !cameraPathPlayer.playing
+	}
+	if input.Pressed("save_path") {
+		if err := cameraPath.Save(cameraPathFile); err != nil {
+			log.Printf("failed to save camera path: %v", err)
+		}
+	}
+	if input.Pressed("load_path") {
+		path, err := LoadCameraPath(cameraPathFile)
+		if err != nil {
+			log.Printf("failed to load camera path: %v", err)
+		} else {
+			cameraPath = path
+			cameraPathPlayer = NewCameraPathPlayer(path)
+		}
+	}
 }
+
 func loadTextures(filePath string, gammaCorrection bool) (texture uint32) {
 	// Load the texture data
 	pixelData, format, width, height := loadPixels(filePath)
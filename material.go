@@ -0,0 +1,144 @@
+package main
+
+import (
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/udhos/gwob"
+)
+
+// TextureSlot is a kind of texture a material can have. Every slot is bound to the texture
+// unit of the same number, so shaders always find a map on the same unit.
+type TextureSlot int
+
+const (
+	SlotDiffuse TextureSlot = iota
+	SlotSpecular
+	SlotNormal
+	SlotHeight
+	SlotAmbient
+	SlotEmissive
+	SlotMetallicRoughness
+	SlotOcclusion
+	TextureSlotCount
+)
+
+// textureSlots names the uniforms of every slot: the sampler, numbered like before materials
+// had slots, and the flag telling whether the map is there.
+var textureSlots = [TextureSlotCount]struct {
+	texType string
+	flag    string
+}{
+	SlotDiffuse:           {"texture_diffuse", "material.hasDiffuseMap"},
+	SlotSpecular:          {"texture_specular", "material.hasSpecularMap"},
+	SlotNormal:            {"texture_normal", "material.hasNormalMap"},
+	SlotHeight:            {"texture_height", "material.hasHeightMap"},
+	SlotAmbient:           {"texture_ambient", "material.hasAmbientMap"},
+	SlotEmissive:          {"texture_emissive", "material.hasEmissiveMap"},
+	SlotMetallicRoughness: {"texture_metallic_roughness", "material.hasMetallicRoughnessMap"},
+	SlotOcclusion:         {"texture_occlusion", "material.hasOcclusionMap"},
+}
+
+// textureSlot returns the slot for a texture type, like texture_diffuse.
+func textureSlot(texType string) (TextureSlot, bool) {
+	for slot, names := range textureSlots {
+		if names.texType == texType {
+			return TextureSlot(slot), true
+		}
+	}
+	return 0, false
+}
+
+// Material is how a mesh looks: its colors, shininess and opacity, and a texture per slot.
+type Material struct {
+	Name      string
+	Ambient   mgl32.Vec3
+	Diffuse   mgl32.Vec3
+	Specular  mgl32.Vec3
+	Emissive  mgl32.Vec3
+	Shininess float32
+	// Opacity is 1 for opaque materials
+	Opacity float32
+	// Textures has a texture with ID 0 for slots without a map
+	Textures [TextureSlotCount]Texture
+}
+
+// defaultMaterial is a plain white material, for meshes that don't name one.
+func defaultMaterial() Material {
+	return Material{
+		Diffuse:   mgl32.Vec3{1, 1, 1},
+		Specular:  mgl32.Vec3{0.5, 0.5, 0.5},
+		Shininess: 32,
+		Opacity:   1,
+	}
+}
+
+// materialFromTextures puts textures in the slot for their type on a default material.
+// Textures of types without a slot, and any after the first of a type, are left out.
+func materialFromTextures(textures []Texture) Material {
+	material := defaultMaterial()
+	for _, texture := range textures {
+		slot, ok := textureSlot(texture.Type)
+		if ok && !material.hasMap(slot) {
+			material.Textures[slot] = texture
+		}
+	}
+	return material
+}
+
+// materialFromMTL copies the colors of an MTL material. Its maps are loaded separately.
+func materialFromMTL(mtl *gwob.Material) Material {
+	material := Material{
+		Name:      mtl.Name,
+		Ambient:   mgl32.Vec3(mtl.Ka),
+		Diffuse:   mgl32.Vec3(mtl.Kd),
+		Specular:  mgl32.Vec3(mtl.Ks),
+		Shininess: mtl.Ns,
+		Opacity:   mtl.D,
+	}
+	// gwob leaves d at 0 when the file doesn't set it, and nobody means to make a whole
+	// material invisible
+	if material.Opacity == 0 {
+		material.Opacity = 1
+	}
+	return material
+}
+
+func (m *Material) hasMap(slot TextureSlot) bool {
+	return m.Textures[slot].ID != 0
+}
+
+// bind binds every slot to its texture unit, unbinding the ones without a map so the maps
+// of the previous mesh don't show through, and sets the uniforms the shader has for them.
+func (m *Material) bind(shader *Shader) {
+	for slot := TextureSlot(0); slot < TextureSlotCount; slot++ {
+		gl.ActiveTexture(gl.TEXTURE0 + uint32(slot))
+		gl.BindTexture(gl.TEXTURE_2D, m.Textures[slot].ID)
+
+		sampler := textureSlots[slot].texType + "1"
+		if shader.hasUniform(sampler) {
+			shader.setInt(sampler, int32(slot))
+		}
+		if flag := textureSlots[slot].flag; shader.hasUniform(flag) {
+			shader.setBool(flag, m.hasMap(slot))
+		}
+	}
+
+	if shader.hasUniform("material.ambientColor") {
+		shader.setVec3("material.ambientColor", m.Ambient)
+	}
+	if shader.hasUniform("material.diffuseColor") {
+		shader.setVec3("material.diffuseColor", m.Diffuse)
+	}
+	if shader.hasUniform("material.specularColor") {
+		shader.setVec3("material.specularColor", m.Specular)
+	}
+	if shader.hasUniform("material.emissiveColor") {
+		shader.setVec3("material.emissiveColor", m.Emissive)
+	}
+	if shader.hasUniform("material.shininess") {
+		shader.setFloat("material.shininess", m.Shininess)
+	}
+	if shader.hasUniform("material.opacity") {
+		shader.setFloat("material.opacity", m.Opacity)
+	}
+}
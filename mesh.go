@@ -1,7 +1,6 @@
 package main
 
 import (
-	"fmt"
 	"unsafe"
 
 	"github.com/go-gl/gl/v4.1-core/gl"
@@ -29,58 +28,79 @@ type Texture struct {
 type Mesh struct {
 	vertices []Vertex
 	indices  []uint32
-	textures []Texture
+	material Material
 	VAO      uint32
 	VBO      uint32
 	EBO      uint32
+	// bounds is the box around the vertices in model space, sphere the sphere around them
+	bounds AABB
+	sphere BoundingSphere
+	// bvh is built on the first raycast that reaches the mesh
+	bvh *BVH
+	// pbr is the metallic-roughness material of meshes loaded from glTF
+	pbr *PBRMaterial
+	// lods are simpler versions of the mesh, coarsest last. Their indices follow the mesh's
+	// own in the EBO.
+	lods []meshLOD
 }
 
+// NewMesh creates a mesh with a default material holding the textures, see
+// materialFromTextures.
 func NewMesh(vertices []Vertex, indices []uint32, textures []Texture) *Mesh {
 	mesh := &Mesh{
 		vertices: vertices,
 		indices:  indices,
-		textures: textures,
+		material: materialFromTextures(textures),
 	}
+	mesh.computeBounds()
 	mesh.setupMesh()
 	return mesh
 }
 
 func (mesh *Mesh) Draw(shader Shader) {
-	// Bind appropriate textures
-	var diffuseNr, specularNr, normalNr, heightNr uint32 = 1, 1, 1, 1
-	for i, texture := range mesh.textures {
-		gl.ActiveTexture(gl.TEXTURE0 + uint32(i))
-		var number string
-		switch texture.Type {
-		case "texture_diffuse":
-			number = fmt.Sprintf("%v", diffuseNr)
-			diffuseNr++
-		case "texture_specular":
-			number = fmt.Sprintf("%v", specularNr)
-			specularNr++
-		case "texture_normal":
-			number = fmt.Sprintf("%v", normalNr)
-			normalNr++
-		case "texture_height":
-			number = fmt.Sprintf("%v", heightNr)
-			heightNr++
-		}
+	mesh.draw(shader, gl.TRIANGLES, 0)
+}
+
+// DrawLOD renders a level of detail of the mesh, 0 for the full mesh. Levels past the
+// coarsest one draw the coarsest one.
+func (mesh *Mesh) DrawLOD(shader Shader, level int) {
+	mesh.draw(shader, gl.TRIANGLES, level)
+}
 
-		textureName := texture.Type + number
+// DrawPatches renders the mesh as patches for a shader with tessellation stages. Every
+// verticesPerPatch indices form one patch.
+func (mesh *Mesh) DrawPatches(shader Shader, verticesPerPatch int32) {
+	gl.PatchParameteri(gl.PATCH_VERTICES, verticesPerPatch)
+	mesh.draw(shader, gl.PATCHES, 0)
+}
 
-		gl.Uniform1i(gl.GetUniformLocation(shader.id, gl.Str(textureName+"\x00")), int32(i))
-		gl.BindTexture(gl.TEXTURE_2D, texture.ID)
+func (mesh *Mesh) draw(shader Shader, mode uint32, level int) {
+	mesh.material.bind(&shader)
+	if mesh.pbr != nil {
+		mesh.pbr.apply(&shader)
+	}
+
+	count, offset := len(mesh.indices), 0
+	if level > 0 && len(mesh.lods) > 0 {
+		lod := mesh.lods[min(level, len(mesh.lods))-1]
+		count, offset = len(lod.indices), lod.offset
 	}
 
 	// Draw mesh
 	gl.BindVertexArray(mesh.VAO)
-	gl.DrawElements(gl.TRIANGLES, int32(len(mesh.indices)), gl.UNSIGNED_INT, unsafe.Pointer(nil))
+	gl.DrawElements(mode, int32(count), gl.UNSIGNED_INT, gl.PtrOffset(offset*int(unsafe.Sizeof(uint32(0)))))
 	gl.BindVertexArray(0)
 
 	// Set everything back to defaults
 	gl.ActiveTexture(gl.TEXTURE0)
 }
 
+// computeBounds fits the bounding box and sphere to the vertices.
+func (mesh *Mesh) computeBounds() {
+	mesh.bounds = computeAABB(mesh.vertices)
+	mesh.sphere = computeBoundingSphere(mesh.vertices, mesh.bounds)
+}
+
 func (mesh *Mesh) setupMesh() {
 	// Create buffers/arrays
 	gl.GenVertexArrays(1, &mesh.VAO)
@@ -93,8 +113,17 @@ func (mesh *Mesh) setupMesh() {
 	gl.BindBuffer(gl.ARRAY_BUFFER, mesh.VBO)
 	gl.BufferData(gl.ARRAY_BUFFER, len(mesh.vertices)*int(unsafe.Sizeof(Vertex{})), unsafe.Pointer(&mesh.vertices[0]), gl.STATIC_DRAW)
 
+	// the levels of detail go after the full mesh, drawn with the same vertices
+	indices := mesh.indices
+	if len(mesh.lods) > 0 {
+		indices = append([]uint32(nil), mesh.indices...)
+		for i := range mesh.lods {
+			mesh.lods[i].offset = len(indices)
+			indices = append(indices, mesh.lods[i].indices...)
+		}
+	}
 	gl.BindBuffer(gl.ELEMENT_ARRAY_BUFFER, mesh.EBO)
-	gl.BufferData(gl.ELEMENT_ARRAY_BUFFER, len(mesh.indices)*int(unsafe.Sizeof(uint32(0))), unsafe.Pointer(&mesh.indices[0]), gl.STATIC_DRAW)
+	gl.BufferData(gl.ELEMENT_ARRAY_BUFFER, len(indices)*int(unsafe.Sizeof(uint32(0))), unsafe.Pointer(&indices[0]), gl.STATIC_DRAW)
 
 	// Set the vertex attribute pointers
 	// Vertex Positions
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// ModelOptions changes how models are processed while they load.
+type ModelOptions struct {
+	// OptimizeVertexCache reorders triangles so vertices are reused while they are still in
+	// the GPU's post-transform cache, and vertices so they are fetched in order. It makes
+	// loading slower and drawing faster.
+	OptimizeVertexCache bool
+	// Normals picks flat or smooth normals for meshes that come without normals.
+	Normals NormalMode
+	// CreaseAngle is the angle in degrees between faces above which smooth normals keep
+	// the edge between them hard. 0 smooths every edge.
+	CreaseAngle float32
+	// CacheMipmaps stores the mipmaps of the textures in the model cache, so loading from it
+	// doesn't have to generate them.
+	CacheMipmaps bool
+	// LODRatios are the fractions of the triangles of every mesh to keep in each level of
+	// detail, finest first, like 0.5, 0.25, 0.125. Empty builds no levels.
+	LODRatios []float32
+}
+
+// ModelStats describes what loading did to the geometry of a model.
+type ModelStats struct {
+	Meshes    int
+	Triangles int
+	// vertices as stored in the file, one per face corner for OBJ files, and after
+	// welding identical ones together
+	VerticesBefore int
+	VerticesAfter  int
+	// average cache miss ratio, the number of vertices transformed per triangle, before and
+	// after optimizing the vertex cache. 0.5 is about as good as it gets, 3 is no reuse at all.
+	ACMRBefore float32
+	ACMRAfter  float32
+	// LODTriangles is the triangles in all levels of detail together
+	LODTriangles int
+}
+
+func (s ModelStats) String() string {
+	str := fmt.Sprintf("%v meshes, %v triangles, %v -> %v vertices, ACMR %.3f -> %.3f",
+		s.Meshes, s.Triangles, s.VerticesBefore, s.VerticesAfter, s.ACMRBefore, s.ACMRAfter)
+	if s.LODTriangles > 0 {
+		str += fmt.Sprintf(", %v LOD triangles", s.LODTriangles)
+	}
+	return str
+}
+
+// Stats returns what loading did to the geometry of the model.
+func (m *Model) Stats() ModelStats {
+	return m.stats
+}
+
+// optimizeMesh welds identical vertices, generates tangents if asked to, and optimizes the
+// vertex cache if the model options ask for it, adding the results to the model stats.
+func (m *Model) optimizeMesh(vertices []Vertex, indices []uint32, tangents bool) ([]Vertex, []uint32) {
+	m.stats.Meshes++
+	m.stats.Triangles += len(indices) / 3
+	m.stats.VerticesBefore += len(vertices)
+
+	vertices, indices = weldVertices(vertices, indices)
+	// after welding, so vertices shared by faces get the tangents of all of them
+	if tangents {
+		vertices, indices = generateTangents(vertices, indices)
+	}
+	before := acmr(indices, vertexCacheSize)
+	if m.options.OptimizeVertexCache {
+		indices = optimizeVertexCache(indices, len(vertices))
+		vertices, indices = optimizeVertexFetch(vertices, indices)
+	}
+
+	// average over all triangles of the model
+	triangles := float32(len(indices) / 3)
+	total := float32(m.stats.Triangles)
+	if total > 0 {
+		previous := total - triangles
+		m.stats.ACMRBefore = (m.stats.ACMRBefore*previous + before*triangles) / total
+		m.stats.ACMRAfter = (m.stats.ACMRAfter*previous + acmr(indices, vertexCacheSize)*triangles) / total
+	}
+	m.stats.VerticesAfter += len(vertices)
+	return vertices, indices
+}
+
+// weldVertices merges vertices with exactly the same attributes and points the indices at the
+// merged ones.
+func weldVertices(vertices []Vertex, indices []uint32) ([]Vertex, []uint32) {
+	welded := make([]Vertex, 0, len(vertices))
+	remap := make(map[Vertex]uint32, len(vertices))
+	newIndices := make([]uint32, len(indices))
+	for i, index := range indices {
+		vertex := vertices[index]
+		newIndex, ok := remap[vertex]
+		if !ok {
+			newIndex = uint32(len(welded))
+			remap[vertex] = newIndex
+			welded = append(welded, vertex)
+		}
+		newIndices[i] = newIndex
+	}
+	return welded, newIndices
+}
+
+// vertexCacheSize is the cache size the optimizer plans for. Real caches vary, but orders
+// that are good for one size are good for others too.
+const vertexCacheSize = 32
+
+// acmr simulates a FIFO vertex cache and returns the average number of cache misses per
+// triangle.
+func acmr(indices []uint32, cacheSize int) float32 {
+	if len(indices) < 3 {
+		return 0
+	}
+	cache := make([]uint32, 0, cacheSize)
+	misses := 0
+	for _, index := range indices {
+		hit := false
+		for _, cached := range cache {
+			if cached == index {
+				hit = true
+				break
+			}
+		}
+		if hit {
+			continue
+		}
+		misses++
+		if len(cache) == cacheSize {
+			cache = cache[1:]
+		}
+		cache = append(cache, index)
+	}
+	return float32(misses) / float32(len(indices)/3)
+}
+
+// Tuning of the vertex scores from Tom Forsyth's "Linear-Speed Vertex Cache Optimisation".
+const (
+	forsythCacheDecayPower   = 1.5
+	forsythLastTriScore      = 0.75
+	forsythValenceBoostScale = 2.0
+	forsythValenceBoostPower = 0.5
+)
+
+// forsythScore rates how much drawing a triangle using a vertex now would help. Vertices
+// that are in the cache, and vertices with few triangles left, score high.
+func forsythScore(cachePosition, remainingTriangles int) float32 {
+	if remainingTriangles == 0 {
+		return -1
+	}
+	score := float32(0)
+	if cachePosition >= 0 {
+		if cachePosition < 3 {
+			// the vertices of the last triangle get a fixed score so the next triangle
+			// doesn't have to be a neighbour of it
+			score = forsythLastTriScore
+		} else {
+			scaler := 1.0 / (vertexCacheSize - 3)
+			score = float32(math.Pow(1-float64(cachePosition-3)*scaler, forsythCacheDecayPower))
+		}
+	}
+	// finish off vertices with few triangles left so they don't have to come back later
+	score += forsythValenceBoostScale * float32(math.Pow(float64(remainingTriangles), -forsythValenceBoostPower))
+	return score
+}
+
+// optimizeVertexCache reorders triangles with Tom Forsyth's greedy algorithm, always drawing
+// the triangle whose vertices score highest next.
+func optimizeVertexCache(indices []uint32, vertexCount int) []uint32 {
+	triangleCount := len(indices) / 3
+	if triangleCount == 0 {
+		return indices
+	}
+
+	// the triangles that still need drawing, per vertex
+	remaining := make([]int, vertexCount)
+	for _, index := range indices[:triangleCount*3] {
+		remaining[index]++
+	}
+	offsets := make([]int, vertexCount+1)
+	for v := 0; v < vertexCount; v++ {
+		offsets[v+1] = offsets[v] + remaining[v]
+	}
+	triangles := make([]int, offsets[vertexCount])
+	filled := make([]int, vertexCount)
+	for t := 0; t < triangleCount; t++ {
+		for _, index := range indices[3*t : 3*t+3] {
+			triangles[offsets[index]+filled[index]] = t
+			filled[index]++
+		}
+	}
+
+	cachePosition := make([]int, vertexCount)
+	score := make([]float32, vertexCount)
+	for v := range score {
+		cachePosition[v] = -1
+		score[v] = forsythScore(-1, remaining[v])
+	}
+	triangleScore := make([]float32, triangleCount)
+	for t := range triangleScore {
+		for _, index := range indices[3*t : 3*t+3] {
+			triangleScore[t] += score[index]
+		}
+	}
+	emitted := make([]bool, triangleCount)
+
+	result := make([]uint32, 0, triangleCount*3)
+	cache := make([]uint32, 0, vertexCacheSize+3)
+	next := -1
+	for len(result) < triangleCount*3 {
+		if next < 0 {
+			// nothing in the cache has triangles left, start somewhere new
+			best := float32(-1)
+			for t := range triangleScore {
+				if !emitted[t] && triangleScore[t] > best {
+					best = triangleScore[t]
+					next = t
+				}
+			}
+		}
+
+		t := next
+		emitted[t] = true
+		corners := indices[3*t : 3*t+3]
+		result = append(result, corners...)
+		for _, index := range corners {
+			// take the triangle off the list of its vertex
+			list := triangles[offsets[index] : offsets[index]+remaining[index]]
+			for i, other := range list {
+				if other == t {
+					list[i] = list[len(list)-1]
+					break
+				}
+			}
+			remaining[index]--
+		}
+
+		// the triangle's vertices move to the front of the cache
+		newCache := make([]uint32, 0, vertexCacheSize+3)
+		newCache = append(newCache, corners...)
+		for _, index := range cache {
+			if index != corners[0] && index != corners[1] && index != corners[2] {
+				newCache = append(newCache, index)
+			}
+		}
+
+		// rescore the vertices that moved, including the ones that fell out of the cache,
+		// and the triangles using them
+		for i, index := range newCache {
+			if i < vertexCacheSize {
+				cachePosition[index] = i
+			} else {
+				cachePosition[index] = -1
+			}
+			newScore := forsythScore(cachePosition[index], remaining[index])
+			delta := newScore - score[index]
+			score[index] = newScore
+			for _, other := range triangles[offsets[index] : offsets[index]+remaining[index]] {
+				triangleScore[other] += delta
+			}
+		}
+
+		next = -1
+		best := float32(-1)
+		for _, index := range newCache[:min(len(newCache), vertexCacheSize)] {
+			for _, other := range triangles[offsets[index] : offsets[index]+remaining[index]] {
+				if triangleScore[other] > best {
+					best = triangleScore[other]
+					next = other
+				}
+			}
+		}
+		cache = newCache[:min(len(newCache), vertexCacheSize)]
+	}
+	return result
+}
+
+// optimizeVertexFetch renumbers vertices in the order the indices first use them, so the
+// vertex buffer is read front to back.
+func optimizeVertexFetch(vertices []Vertex, indices []uint32) ([]Vertex, []uint32) {
+	remap := make([]int64, len(vertices))
+	for i := range remap {
+		remap[i] = -1
+	}
+	ordered := make([]Vertex, 0, len(vertices))
+	newIndices := make([]uint32, len(indices))
+	for i, index := range indices {
+		if remap[index] < 0 {
+			remap[index] = int64(len(ordered))
+			ordered = append(ordered, vertices[index])
+		}
+		newIndices[i] = uint32(remap[index])
+	}
+	return ordered, newIndices
+}
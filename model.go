@@ -1,12 +1,16 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"image"
+	"image/color"
 	_ "image/jpeg"
 	_ "image/png"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/go-gl/gl/v4.1-core/gl"
 	"github.com/go-gl/mathgl/mgl32"
@@ -18,48 +22,172 @@ type Model struct {
 	meshes          []Mesh
 	directory       string
 	gammaCorrection bool
+	// warnings are the problems that didn't stop the model from loading
+	warnings []string
+	options  ModelOptions
+	stats    ModelStats
+	// skeleton and animations of skinned glTF models, nil for others
+	skeleton   *Skeleton
+	animations []*AnimationClip
+	// sources are the files the model was loaded from
+	sources []string
+	// textures are decoded but not uploaded yet. Until upload, the texture IDs of the
+	// materials are indices into it plus 1. Data without levels stands for the placeholder.
+	textures []TextureData
+	// decodes are the textures that are still empty until decodeTextures
+	decodes []textureDecode
 }
 
-// LoadModel loads the model from the given path.
-func LoadModel(path string) *Model {
-	m := &Model{}
-	dirName := filepath.Base(path)
-	objPath := filepath.Join(path, dirName+".obj")
-	mtlPath := filepath.Join(path, dirName+".mtl")
-	m.directory = path
+// textureDecode is a texture of a model whose image hasn't been decoded yet.
+type textureDecode struct {
+	index  int
+	decode func() (TextureData, error)
+}
 
-	// Initialize texturesLoaded map if not already initialized
-	if m.texturesLoaded == nil {
-		m.texturesLoaded = make(map[string]Texture)
+// newModel returns an empty model for files in directory.
+func newModel(directory string, options ModelOptions) *Model {
+	return &Model{
+		directory:      directory,
+		texturesLoaded: make(map[string]Texture),
+		options:        options,
 	}
+}
 
-	// Load the OBJ file
-	options := &gwob.ObjParserOptions{}
-	obj, err := gwob.NewObjFromFile(objPath, options)
+// LoadModel loads an OBJ model and the material library it names with mtllib, or a glTF
+// model if the path ends in .gltf or .glb. For older code, path can also be a directory
+// holding <dir>/<dir>.obj.
+//
+// Only problems with the model file itself are errors. Missing materials and textures are
+// replaced by placeholders and reported in Warnings.
+//
+// The processed model is written to modelCacheDir, and loaded from there as long as none of
+// the files it was loaded from change.
+func LoadModel(path string) (*Model, error) {
+	return LoadModelWithOptions(path, ModelOptions{})
+}
+
+// LoadModelWithOptions loads a model like LoadModel, processing it as options say.
+func LoadModelWithOptions(path string, options ModelOptions) (*Model, error) {
+	m, err := loadModelData(path, options, decodeInOrder)
 	if err != nil {
-		log.Fatalf("Failed to load OBJ model: %v", err)
+		return nil, err
 	}
+	m.upload()
+	return m, nil
+}
 
-	// Load the MTL file
-	mtlLib, err := gwob.ReadMaterialLibFromFile(mtlPath, nil)
+// loadModelData does the part of loading a model that doesn't need OpenGL, from the cache if
+// it can. It's safe to call from any goroutine. The model can't be drawn before upload.
+//
+// run runs the image decodes of the model and returns when they're all done, see
+// decodeTextures.
+func loadModelData(path string, options ModelOptions, run func(decodes []func())) (*Model, error) {
+	if modelCacheDir == "" {
+		m, err := loadModel(path, options)
+		if err != nil {
+			return nil, err
+		}
+		m.decodeTextures(run)
+		return m, nil
+	}
+	key := modelCacheKey(path, options)
+	if m, err := loadCachedModel(key, options); err == nil {
+		return m, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		log.Printf("not using cached model: %v", err)
+	}
+
+	m, err := loadModel(path, options)
 	if err != nil {
-		log.Fatalf("Failed to load MTL file: %v", err)
+		return nil, err
+	}
+	m.decodeTextures(run)
+	storeModel(m, key)
+	return m, nil
+}
+
+// loadModel loads a model from its source files, without uploading it.
+func loadModel(path string, options ModelOptions) (*Model, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gltf", ".glb":
+		return loadGLTF(path, options)
+	}
+
+	objPath := path
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		objPath = filepath.Join(path, filepath.Base(path)+".obj")
+	}
+
+	m := newModel(filepath.Dir(objPath), options)
+	m.source(objPath)
+
+	// Load the OBJ file
+	parserOptions := &gwob.ObjParserOptions{
+		Logger: func(message string) { m.warn("%v: %v", objPath, message) },
+	}
+	obj, err := gwob.NewObjFromFile(objPath, parserOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OBJ model: %w", err)
+	}
+
+	// Load the MTL file named by the model, relative to it
+	mtlLib := gwob.NewMaterialLib()
+	if obj.Mtllib != "" {
+		mtlPath := filepath.Join(m.directory, filepath.FromSlash(obj.Mtllib))
+		m.source(mtlPath)
+		lib, err := gwob.ReadMaterialLibFromFile(mtlPath, parserOptions)
+		if err != nil {
+			m.warn("failed to load MTL file, using default materials: %v", err)
+		} else {
+			mtlLib = lib
+		}
 	}
 
 	// Process each group (mesh) in the OBJ file
 	for _, group := range obj.Groups {
+		// gwob leaves empty groups behind, for example the default group when the file
+		// starts with a named one
+		if group.IndexCount <= 0 {
+			continue
+		}
+		if group.Usemtl != "" {
+			if _, ok := mtlLib.Lib[group.Usemtl]; !ok {
+				m.warn("group %q uses unknown material %q", group.Name, group.Usemtl)
+			}
+		}
 		mesh := m.processMesh(group, obj, mtlLib)
 		m.meshes = append(m.meshes, mesh)
 	}
+	if len(m.meshes) == 0 {
+		return nil, fmt.Errorf("%v has no faces", objPath)
+	}
+
+	return m, nil
+}
+
+// Warnings returns the problems found while loading the model that didn't stop it from loading.
+func (m *Model) Warnings() []string {
+	return m.warnings
+}
 
-	return m
+// Skeleton returns the bones of a skinned model, or nil if it has none.
+func (m *Model) Skeleton() *Skeleton {
+	return m.skeleton
+}
+
+// Animations returns the animation clips of the model skeleton.
+func (m *Model) Animations() []*AnimationClip {
+	return m.animations
+}
+
+func (m *Model) warn(format string, args ...any) {
+	m.warnings = append(m.warnings, fmt.Sprintf(format, args...))
 }
 
 // processMesh processes a group in the OBJ file and converts it into a Mesh structure.
 func (m *Model) processMesh(group *gwob.Group, obj *gwob.Obj, mtlLib gwob.MaterialLib) Mesh {
 	var vertices []Vertex
 	var indices []uint32
-	var textures []Texture
 
 	// Process vertices and indices
 	for i := group.IndexBegin; i < group.IndexBegin+group.IndexCount; i++ {
@@ -105,54 +233,70 @@ func (m *Model) processMesh(group *gwob.Group, obj *gwob.Obj, mtlLib gwob.Materi
 		vertices = append(vertices, vertex)
 		indices = append(indices, uint32(len(vertices)-1))
 	}
+	if !obj.NormCoordFound {
+		vertices, indices = generateNormals(vertices, indices, m.options.smoothNormals(group.Smooth != 0), m.options.CreaseAngle)
+	}
 
-	// Process materials (textures)
-	if group.Usemtl != "" {
-		if material, exists := mtlLib.Lib[group.Usemtl]; exists {
-			textures = append(textures, m.loadMaterialTextures(material)...)
-		}
+	// OBJ indices point at positions, normals and texture coordinates separately, so every
+	// corner became its own vertex. Share the ones that are the same.
+	vertices, indices = m.optimizeMesh(vertices, indices, obj.TextCoordFound)
+
+	material := defaultMaterial()
+	if mtl, exists := mtlLib.Lib[group.Usemtl]; exists {
+		material = m.loadMaterial(mtl)
 	}
 
 	mesh := Mesh{
 		vertices: vertices,
 		indices:  indices,
-		textures: textures,
+		material: material,
 	}
-	mesh.setupMesh()
+	mesh.computeBounds()
+	mesh.lods = m.meshLODs(mesh.vertices, mesh.indices, mesh.sphere)
 
 	return mesh
 }
 
-// loadMaterialTextures loads textures for a given material.
-func (m *Model) loadMaterialTextures(material *gwob.Material) []Texture {
-	var textures []Texture
-
-	// Map the material fields to the corresponding texture types
-	textureMappings := map[string]string{
-		material.MapKd: "texture_diffuse",
-		material.MapKs: "texture_specular",
-		material.Bump:  "texture_normal",
-		material.MapD:  "texture_height",
+// loadMaterial turns an MTL material into a Material, loading its maps.
+func (m *Model) loadMaterial(mtl *gwob.Material) Material {
+	material := materialFromMTL(mtl)
+
+	// map_d has always ended up as texture_height, keep it there for the shaders using it
+	maps := []struct {
+		path string
+		slot TextureSlot
+	}{
+		{mtl.MapKd, SlotDiffuse},
+		{mtl.MapKs, SlotSpecular},
+		{mtl.Bump, SlotNormal},
+		{mtl.MapD, SlotHeight},
+		{mtl.MapKa, SlotAmbient},
+		{mtl.MapKe, SlotEmissive},
 	}
-
-	for texPath, texType := range textureMappings {
-		if texPath != "" { // Ensure the texture path is not empty
-			if texture, loaded := m.texturesLoaded[texPath]; loaded {
-				textures = append(textures, texture)
-			} else {
-				texture := m.loadTexture(texPath, texType)
-				textures = append(textures, texture)
-				m.texturesLoaded[texPath] = texture
-			}
+	for _, textureMap := range maps {
+		if textureMap.path == "" {
+			continue
+		}
+		texType := textureSlots[textureMap.slot].texType
+		texture, loaded := m.texturesLoaded[textureMap.path]
+		if !loaded {
+			texture = m.loadTexture(textureMap.path, texType)
+			m.texturesLoaded[textureMap.path] = texture
 		}
+		texture.Type = texType
+		material.Textures[textureMap.slot] = texture
 	}
-
-	return textures
+	return material
 }
 
-// loadTexture loads a texture from the file.
+// loadTexture loads a texture from the file. Textures that fail to load are replaced by a
+// checkerboard, so they stand out without stopping the model from loading.
 func (m *Model) loadTexture(path, texType string) Texture {
-	textureID := TextureFromFile(path, m.directory, m.gammaCorrection)
+	filename := filepath.Join(m.directory, filepath.FromSlash(path))
+	m.source(filename)
+	textureID := m.addTextureDecode(func() (TextureData, error) {
+		return decodeTextureFile(filename)
+	})
 
 	return Texture{
 		ID:   textureID,
@@ -161,45 +305,229 @@ func (m *Model) loadTexture(path, texType string) Texture {
 	}
 }
 
-// Draw renders the model using the provided shader.
-func (m *Model) Draw(shader Shader) {
+// addTexture adds decoded texture data to the model and returns the texture ID to use until
+// upload. Data without levels gets the placeholder.
+func (m *Model) addTexture(data TextureData) uint32 {
+	m.textures = append(m.textures, m.withCachedMipmaps(data))
+	return uint32(len(m.textures))
+}
+
+// addTextureDecode adds a texture whose image is decoded later, by decodeTextures, and
+// returns the texture ID to use until upload.
+func (m *Model) addTextureDecode(decode func() (TextureData, error)) uint32 {
+	m.textures = append(m.textures, TextureData{})
+	m.decodes = append(m.decodes, textureDecode{index: len(m.textures) - 1, decode: decode})
+	return uint32(len(m.textures))
+}
+
+// withCachedMipmaps adds the mipmaps to the data when they go into the model cache.
+func (m *Model) withCachedMipmaps(data TextureData) TextureData {
+	if m.options.CacheMipmaps && modelCacheDir != "" && len(data.Levels) > 0 {
+		return data.withMipmaps()
+	}
+	return data
+}
+
+// decodeTextures decodes the images of the textures added with addTextureDecode. run gets a
+// function per image and has to return once they have all run, which may be in parallel.
+// Images that fail to decode keep the placeholder.
+func (m *Model) decodeTextures(run func(decodes []func())) {
+	errs := make([]error, len(m.decodes))
+	decodes := make([]func(), len(m.decodes))
+	for i, d := range m.decodes {
+		decodes[i] = func() {
+			data, err := d.decode()
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			// every decode has its own element, the slice doesn't grow while they run
+			m.textures[d.index] = m.withCachedMipmaps(data)
+		}
+	}
+	run(decodes)
+	// warnings come out in the order the textures were added, however the decodes ran
+	for _, err := range errs {
+		if err != nil {
+			m.warn("%v, using a placeholder", err)
+		}
+	}
+	m.decodes = nil
+}
+
+// decodeInOrder runs the decodes one after the other, for decodeTextures.
+func decodeInOrder(decodes []func()) {
+	for _, decode := range decodes {
+		decode()
+	}
+}
+
+// uploadSteps returns the OpenGL work left after loading a model: uploading every texture,
+// then setting up every mesh. The steps have to run in order on the thread with the context.
+func (m *Model) uploadSteps() []func() {
+	textureIDs := make([]uint32, len(m.textures))
+	var steps []func()
+	for i := range m.textures {
+		steps = append(steps, func() {
+			if len(m.textures[i].Levels) == 0 {
+				textureIDs[i] = placeholderTexture()
+			} else {
+				textureIDs[i] = m.textures[i].upload()
+			}
+		})
+	}
+	for i := range m.meshes {
+		steps = append(steps, func() {
+			mesh := &m.meshes[i]
+			for slot, texture := range mesh.material.Textures {
+				if texture.ID != 0 {
+					mesh.material.Textures[slot].ID = textureIDs[texture.ID-1]
+				}
+			}
+			mesh.setupMesh()
+		})
+	}
+	steps = append(steps, func() {
+		for path, texture := range m.texturesLoaded {
+			if texture.ID != 0 {
+				texture.ID = textureIDs[texture.ID-1]
+				m.texturesLoaded[path] = texture
+			}
+		}
+		// the pixels are on the GPU now
+		m.textures = nil
+	})
+	return steps
+}
+
+// upload creates the textures and buffers of a loaded model.
+func (m *Model) upload() {
+	for _, step := range m.uploadSteps() {
+		step()
+	}
+}
+
+// Draw renders the model using the provided shader. Meshes outside frustum are skipped and
+// counted in culled. The frustum has to be in the model's local space, see Camera.frustum.
+// Pass nil to draw every mesh.
+//
+// Meshes with levels of detail are drawn with the coarsest one that looks right from view,
+// see Camera.lodView. Pass nil to draw the full meshes.
+func (m *Model) Draw(shader Shader, frustum *Frustum, view *LODView) (culled int) {
 	for _, mesh := range m.meshes {
-		mesh.Draw(shader)
+		if frustum != nil && !frustum.intersectsAABB(mesh.bounds) {
+			culled++
+			continue
+		}
+		mesh.DrawLOD(shader, mesh.selectLOD(view))
 	}
+	return culled
 }
 
-// TextureFromFile loads a texture from a file and returns the OpenGL texture ID.
-func TextureFromFile(path, directory string, gamma bool) uint32 {
-	filename := filepath.Join(directory, path)
+// bounds returns the box around every mesh of the model.
+func (m *Model) bounds() AABB {
+	if len(m.meshes) == 0 {
+		return AABB{}
+	}
+	b := m.meshes[0].bounds
+	for _, mesh := range m.meshes[1:] {
+		b = b.union(mesh.bounds)
+	}
+	return b
+}
 
-	// Generate and bind a new texture ID
-	var textureID uint32
-	gl.GenTextures(1, &textureID)
-	gl.BindTexture(gl.TEXTURE_2D, textureID)
+// DrawPatches renders the model as patches for a shader with tessellation stages. The meshes
+// are triangle lists, so use 3 vertices per patch unless the indices were built otherwise.
+func (m *Model) DrawPatches(shader Shader, verticesPerPatch int32) {
+	for _, mesh := range m.meshes {
+		mesh.DrawPatches(shader, verticesPerPatch)
+	}
+}
+
+// TextureFromFile loads a texture from a file and returns the OpenGL texture ID.
+func TextureFromFile(path, directory string, gamma bool) (uint32, error) {
+	data, err := decodeTextureFile(filepath.Join(directory, filepath.FromSlash(path)))
+	if err != nil {
+		return 0, err
+	}
+	return data.upload(), nil
+}
 
+// decodeTextureFile reads and decodes an image file into texture data.
+func decodeTextureFile(filename string) (TextureData, error) {
 	// Open and decode the texture image file
 	textureFile, err := os.Open(filename)
 	if err != nil {
-		log.Fatalf("Failed to open texture file: %v", err)
+		return TextureData{}, fmt.Errorf("failed to open texture file: %w", err)
 	}
 	defer textureFile.Close()
 
 	// Decode the image (JPEG, PNG, etc.)
 	textureImage, _, err := image.Decode(textureFile)
 	if err != nil {
-		log.Fatalf("Failed to decode texture file [%s]: %v", filename, err)
+		return TextureData{}, fmt.Errorf("failed to decode texture file [%s]: %w", filename, err)
+	}
+
+	return imageTextureData(textureImage), nil
+}
+
+var placeholderTextureID uint32
+
+// placeholderTexture returns a magenta and black checkerboard texture that stands in for
+// textures that couldn't be loaded. It's created on first use and shared.
+func placeholderTexture() uint32 {
+	if placeholderTextureID != 0 {
+		return placeholderTextureID
+	}
+	const size, square = 64, 8
+	checkerboard := image.NewNRGBA(image.Rect(0, 0, size, size))
+	magenta := color.NRGBA{R: 255, B: 255, A: 255}
+	black := color.NRGBA{A: 255}
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if (x/square+y/square)%2 == 0 {
+				checkerboard.SetNRGBA(x, y, magenta)
+			} else {
+				checkerboard.SetNRGBA(x, y, black)
+			}
+		}
 	}
+	placeholderTextureID = textureFromImage(checkerboard)
+	// keep the squares sharp
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	return placeholderTextureID
+}
+
+// textureFromImage uploads a decoded image to a new texture and returns the OpenGL texture ID.
+// The texture is left bound.
+func textureFromImage(textureImage image.Image) uint32 {
+	return imageTextureData(textureImage).upload()
+}
 
-	// Determine the number of components and the OpenGL format
+// TextureData is the pixels of a texture, bottom row first like OpenGL wants them.
+type TextureData struct {
+	Width, Height int
+	// Channels is 1 for red, 3 for RGB and 4 for RGBA
+	Channels int
+	// SRGB colors are converted to linear when sampled, for gamma correction
+	SRGB bool
+	// Levels is the mip chain starting with the full size image, or only that image when
+	// the driver should generate the mipmaps
+	Levels [][]byte
+}
+
+// imageTextureData converts a decoded image into texture data.
+func imageTextureData(textureImage image.Image) TextureData {
+	// Determine the number of components
 	bounds := textureImage.Bounds()
 	width, height := bounds.Dx(), bounds.Dy()
-	var format, internalFormat int32
+	var channels int
 	var pixelData []byte
 
 	switch img := textureImage.(type) {
 	case *image.Gray:
-		format = gl.RED
-		internalFormat = gl.RED
+		channels = 1
 		pixelData = make([]byte, width*height)
 		index := 0
 		for y := bounds.Max.Y - 1; y >= bounds.Min.Y; y-- {
@@ -226,8 +554,7 @@ func TextureFromFile(path, directory string, gamma bool) uint32 {
 		}
 
 		if hasAlpha {
-			format = gl.RGBA
-			internalFormat = gl.RGBA
+			channels = 4
 			pixelData = make([]byte, width*height*4)
 			index := 0
 			for y := bounds.Max.Y - 1; y >= bounds.Min.Y; y-- {
@@ -242,8 +569,7 @@ func TextureFromFile(path, directory string, gamma bool) uint32 {
 			}
 		} else {
 			// Treat as RGB if alpha is not used
-			format = gl.RGB
-			internalFormat = gl.RGB
+			channels = 3
 			pixelData = make([]byte, width*height*3)
 			index := 0
 			for y := bounds.Max.Y - 1; y >= bounds.Min.Y; y-- {
@@ -257,8 +583,7 @@ func TextureFromFile(path, directory string, gamma bool) uint32 {
 			}
 		}
 	case *image.NRGBA:
-		format = gl.RGBA
-		internalFormat = gl.RGBA
+		channels = 4
 		pixelData = make([]byte, width*height*4)
 		index := 0
 		for y := bounds.Max.Y - 1; y >= bounds.Min.Y; y-- {
@@ -273,8 +598,7 @@ func TextureFromFile(path, directory string, gamma bool) uint32 {
 		}
 	default:
 		// Handle RGB images without alpha channel
-		format = gl.RGB
-		internalFormat = gl.RGB
+		channels = 3
 		pixelData = make([]byte, width*height*3)
 		index := 0
 		for y := bounds.Max.Y - 1; y >= bounds.Min.Y; y-- {
@@ -288,11 +612,42 @@ func TextureFromFile(path, directory string, gamma bool) uint32 {
 		}
 	}
 
+	return TextureData{Width: width, Height: height, Channels: channels, Levels: [][]byte{pixelData}}
+}
+
+var textureFormats = map[int]int32{1: gl.RED, 3: gl.RGB, 4: gl.RGBA}
+
+// upload creates a texture from the data and returns the OpenGL texture ID. The texture is
+// left bound.
+func (data TextureData) upload() uint32 {
+	// Generate and bind a new texture ID
+	var textureID uint32
+	gl.GenTextures(1, &textureID)
+	gl.BindTexture(gl.TEXTURE_2D, textureID)
+
+	// rows of RGB and red textures aren't padded to 4 bytes
+	gl.PixelStorei(gl.UNPACK_ALIGNMENT, 1)
+	format := textureFormats[data.Channels]
+	internalFormat := format
+	if data.SRGB {
+		switch format {
+		case gl.RGB:
+			internalFormat = gl.SRGB
+		case gl.RGBA:
+			internalFormat = gl.SRGB_ALPHA
+		}
+	}
+
 	// Upload texture data to the GPU
-	gl.TexImage2D(gl.TEXTURE_2D, 0, internalFormat, int32(width), int32(height), 0, uint32(format), gl.UNSIGNED_BYTE, gl.Ptr(pixelData))
+	for level, pixels := range data.Levels {
+		width, height := mipSize(data.Width, level), mipSize(data.Height, level)
+		gl.TexImage2D(gl.TEXTURE_2D, int32(level), internalFormat, int32(width), int32(height), 0, uint32(format), gl.UNSIGNED_BYTE, gl.Ptr(pixels))
+	}
 
-	// Generate mipmaps
-	gl.GenerateMipmap(gl.TEXTURE_2D)
+	// Generate mipmaps, unless they came with the data
+	if len(data.Levels) == 1 {
+		gl.GenerateMipmap(gl.TEXTURE_2D)
+	}
 
 	// Set texture wrapping/filtering options
 	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_WRAP_S, gl.REPEAT)
@@ -302,3 +657,40 @@ func TextureFromFile(path, directory string, gamma bool) uint32 {
 
 	return textureID
 }
+
+// mipSize is the size of a texture side at a mip level.
+func mipSize(size, level int) int {
+	return max(size>>level, 1)
+}
+
+// withMipmaps returns the data with a full mip chain down to 1x1, each level averaging 2x2
+// pixels of the one above. Data that has mipmaps already is returned as is.
+func (data TextureData) withMipmaps() TextureData {
+	if len(data.Levels) != 1 {
+		return data
+	}
+	levels := [][]byte{data.Levels[0]}
+	for level := 1; mipSize(data.Width, level-1) > 1 || mipSize(data.Height, level-1) > 1; level++ {
+		previous := levels[level-1]
+		previousWidth, previousHeight := mipSize(data.Width, level-1), mipSize(data.Height, level-1)
+		width, height := mipSize(data.Width, level), mipSize(data.Height, level)
+		pixels := make([]byte, width*height*data.Channels)
+		for y := 0; y < height; y++ {
+			// sides of 1 pixel stay 1 pixel, they have nothing to average with
+			y0, y1 := min(2*y, previousHeight-1), min(2*y+1, previousHeight-1)
+			for x := 0; x < width; x++ {
+				x0, x1 := min(2*x, previousWidth-1), min(2*x+1, previousWidth-1)
+				for c := 0; c < data.Channels; c++ {
+					sum := int(previous[(y0*previousWidth+x0)*data.Channels+c]) +
+						int(previous[(y0*previousWidth+x1)*data.Channels+c]) +
+						int(previous[(y1*previousWidth+x0)*data.Channels+c]) +
+						int(previous[(y1*previousWidth+x1)*data.Channels+c])
+					pixels[(y*width+x)*data.Channels+c] = byte((sum + 2) / 4)
+				}
+			}
+		}
+		levels = append(levels, pixels)
+	}
+	data.Levels = levels
+	return data
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+	"unsafe"
+)
+
+// modelCacheDir is where processed models are cached, so later runs skip parsing and image
+// decoding. Set it to an empty string to always load models from their source files.
+var modelCacheDir = defaultModelCacheDir()
+
+func defaultModelCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "learn-opengl", "models")
+}
+
+// A cache file is a header, the vertex, index and pixel arrays, each aligned to
+// modelCacheAlign bytes so they can be used in place, and a gob encoded cachedModel
+// describing them at the end.
+//
+//	magic       [8]byte
+//	version     uint32
+//	byte order  uint32, 1 in the byte order of the machine that wrote the file
+//	vertex size uint32, the size of Vertex
+//	checksum    uint32, CRC-32C of everything after the header
+//	meta offset uint64, where the cachedModel starts
+//
+// The cache key only covers the source files and the ModelOptions, not the code that
+// processes them. Bump modelCacheVersion when the output of any of these changes, or old
+// files keep being served: processMesh, loadMaterial, loadTexture and decodeTextures, the
+// gltfLoader, generateNormals, weldVertices, generateTangents, optimizeVertexCache,
+// optimizeVertexFetch, imageTextureData, withMipmaps, newSimplifier, simplify and meshLODs,
+// and the layout of Vertex, Material, PBRMaterial, Skeleton or AnimationClip.
+const (
+	modelCacheMagic      = "LOGLMDL\x00"
+	modelCacheVersion    = 5
+	modelCacheHeaderSize = 32
+	modelCacheAlign      = 16
+)
+
+var modelCacheTable = crc32.MakeTable(crc32.Castagnoli)
+
+// cachedModel is everything about a model except its arrays, which are blobs in the file.
+type cachedModel struct {
+	Directory string
+	Sources   []cachedSource
+	Warnings  []string
+	Stats     ModelStats
+	Textures  []cachedTexture
+	// TexturesLoaded and the materials of Meshes use texture IDs from before the upload
+	TexturesLoaded map[string]Texture
+	Meshes         []cachedMesh
+	Skeleton       *Skeleton
+	Animations     []*AnimationClip
+}
+
+// cachedSource is a file the model was built from. Files that are changed, or created when
+// they were missing, make the cache stale.
+type cachedSource struct {
+	Path    string
+	Missing bool
+	Size    int64
+	ModTime time.Time
+	Hash    [sha256.Size]byte
+}
+
+// cachedTexture is a TextureData, without levels for the placeholder.
+type cachedTexture struct {
+	Width    int
+	Height   int
+	Channels int
+	SRGB     bool
+	Levels   []cachedBlob
+}
+
+type cachedMesh struct {
+	Vertices cachedBlob
+	Indices  cachedBlob
+	Material Material
+	PBR      *PBRMaterial
+	LODs     []cachedLOD
+}
+
+type cachedLOD struct {
+	Indices cachedBlob
+	Error   float32
+}
+
+// cachedBlob is a byte range of the cache file.
+type cachedBlob struct {
+	Offset int64
+	Length int64
+}
+
+// modelCacheKey names the cache file of a model loaded with some options.
+func modelCacheKey(path string, options ModelOptions) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%v\x00%+v", path, options)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// source records a file the model is built from, to check the cache against later.
+func (m *Model) source(path string) {
+	m.sources = append(m.sources, path)
+}
+
+// statSource describes a source file as it is now. The hash is only computed when asked for,
+// reading big files takes a while.
+func statSource(path string, withHash bool) (cachedSource, error) {
+	source := cachedSource{Path: path}
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		source.Missing = true
+		return source, nil
+	}
+	if err != nil {
+		return source, err
+	}
+	source.Size = info.Size()
+	source.ModTime = info.ModTime()
+	if withHash {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return source, err
+		}
+		source.Hash = sha256.Sum256(data)
+	}
+	return source, nil
+}
+
+// fresh reports whether a source file is unchanged. A file with a new modification time
+// still counts as unchanged if its contents are, like after a fresh checkout.
+func (s cachedSource) fresh() bool {
+	current, err := statSource(s.Path, false)
+	if err != nil || current.Missing != s.Missing {
+		return false
+	}
+	if s.Missing || current.Size == s.Size && current.ModTime.Equal(s.ModTime) {
+		return true
+	}
+	if current.Size != s.Size {
+		return false
+	}
+	current, err = statSource(s.Path, true)
+	return err == nil && current.Hash == s.Hash
+}
+
+// loadCachedModel loads a model from the cache. It fails if there is no cache file for key,
+// if any source file changed or if the file is damaged. Damaged files are removed.
+func loadCachedModel(key string, options ModelOptions) (*Model, error) {
+	path := filepath.Join(modelCacheDir, key+".bin")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := readModelCache(data)
+	if err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	for _, source := range meta.Sources {
+		if !source.fresh() {
+			return nil, fmt.Errorf("%v changed", source.Path)
+		}
+	}
+	return meta.model(data, options)
+}
+
+// readModelCache checks the header and checksum of a cache file and decodes its cachedModel.
+func readModelCache(data []byte) (*cachedModel, error) {
+	if len(data) < modelCacheHeaderSize || string(data[:8]) != modelCacheMagic {
+		return nil, errors.New("not a model cache file")
+	}
+	if version := binary.LittleEndian.Uint32(data[8:]); version != modelCacheVersion {
+		return nil, fmt.Errorf("model cache version %v, expected %v", version, modelCacheVersion)
+	}
+	// arrays are used as they are in memory, they have to match this machine
+	if binary.LittleEndian.Uint32(data[12:]) != 1 || binary.LittleEndian.Uint32(data[16:]) != uint32(unsafe.Sizeof(Vertex{})) {
+		return nil, errors.New("model cache was written by a different build")
+	}
+	if crc32.Checksum(data[modelCacheHeaderSize:], modelCacheTable) != binary.LittleEndian.Uint32(data[20:]) {
+		return nil, errors.New("model cache checksum mismatch")
+	}
+	metaOffset := binary.LittleEndian.Uint64(data[24:])
+	if metaOffset < modelCacheHeaderSize || metaOffset > uint64(len(data)) {
+		return nil, errors.New("model cache is truncated")
+	}
+	var meta cachedModel
+	if err := gob.NewDecoder(bytes.NewReader(data[metaOffset:])).Decode(&meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// blob returns the bytes of a blob, without copying them.
+func blob(data []byte, b cachedBlob) ([]byte, error) {
+	if b.Offset < modelCacheHeaderSize || b.Length < 0 || b.Offset+b.Length > int64(len(data)) {
+		return nil, errors.New("model cache blob is outside of the file")
+	}
+	return data[b.Offset : b.Offset+b.Length], nil
+}
+
+// model returns the cached model, ready for upload. The vertices and indices of the meshes
+// and the texture pixels point into data.
+func (c *cachedModel) model(data []byte, options ModelOptions) (*Model, error) {
+	m := newModel(c.Directory, options)
+	m.warnings = c.Warnings
+	m.stats = c.Stats
+	m.animations = c.Animations
+	if c.TexturesLoaded != nil {
+		m.texturesLoaded = c.TexturesLoaded
+	}
+	if c.Skeleton != nil {
+		m.skeleton = newSkeleton(c.Skeleton.Nodes)
+		m.skeleton.Joints = c.Skeleton.Joints
+		m.skeleton.InverseBindMatrices = c.Skeleton.InverseBindMatrices
+	}
+
+	for i, texture := range c.Textures {
+		textureData := TextureData{Width: texture.Width, Height: texture.Height, Channels: texture.Channels, SRGB: texture.SRGB}
+		for level, levelBlob := range texture.Levels {
+			pixels, err := blob(data, levelBlob)
+			if err != nil {
+				return nil, err
+			}
+			if len(pixels) != mipSize(texture.Width, level)*mipSize(texture.Height, level)*texture.Channels {
+				return nil, fmt.Errorf("texture %v level %v has the wrong size", i, level)
+			}
+			textureData.Levels = append(textureData.Levels, pixels)
+		}
+		m.textures = append(m.textures, textureData)
+	}
+	for _, texture := range m.texturesLoaded {
+		if int(texture.ID) > len(m.textures) {
+			return nil, errors.New("model cache has a texture that isn't there")
+		}
+	}
+
+	for _, cached := range c.Meshes {
+		vertexBytes, err := blob(data, cached.Vertices)
+		if err != nil {
+			return nil, err
+		}
+		indexBytes, err := blob(data, cached.Indices)
+		if err != nil {
+			return nil, err
+		}
+		vertexSize := int(unsafe.Sizeof(Vertex{}))
+		if len(vertexBytes) == 0 || len(vertexBytes)%vertexSize != 0 || len(indexBytes) == 0 || len(indexBytes)%4 != 0 {
+			return nil, errors.New("model cache mesh has the wrong size")
+		}
+
+		mesh := Mesh{
+			// blobs are aligned, so the arrays can be used where they are
+			vertices: unsafe.Slice((*Vertex)(unsafe.Pointer(&vertexBytes[0])), len(vertexBytes)/vertexSize),
+			indices:  unsafe.Slice((*uint32)(unsafe.Pointer(&indexBytes[0])), len(indexBytes)/4),
+			material: cached.Material,
+			pbr:      cached.PBR,
+		}
+		for _, index := range mesh.indices {
+			if int(index) >= len(mesh.vertices) {
+				return nil, errors.New("model cache mesh has an index out of range")
+			}
+		}
+		for _, texture := range mesh.material.Textures {
+			if int(texture.ID) > len(m.textures) {
+				return nil, errors.New("model cache material uses a texture that isn't there")
+			}
+		}
+		for _, cachedLOD := range cached.LODs {
+			lodBytes, err := blob(data, cachedLOD.Indices)
+			if err != nil {
+				return nil, err
+			}
+			if len(lodBytes) == 0 || len(lodBytes)%4 != 0 {
+				return nil, errors.New("model cache level of detail has the wrong size")
+			}
+			lod := meshLOD{
+				indices: unsafe.Slice((*uint32)(unsafe.Pointer(&lodBytes[0])), len(lodBytes)/4),
+				error:   cachedLOD.Error,
+			}
+			for _, index := range lod.indices {
+				if int(index) >= len(mesh.vertices) {
+					return nil, errors.New("model cache level of detail has an index out of range")
+				}
+			}
+			mesh.lods = append(mesh.lods, lod)
+		}
+		mesh.computeBounds()
+		m.meshes = append(m.meshes, mesh)
+	}
+	return m, nil
+}
+
+// storeModel writes a model that was just loaded to the cache. It has to run before upload,
+// while the model still has its texture data.
+func storeModel(m *Model, key string) {
+	if err := writeModelCache(m, key); err != nil {
+		log.Printf("failed to cache model: %v", err)
+	}
+}
+
+func writeModelCache(m *Model, key string) error {
+	meta := cachedModel{
+		Directory:  m.directory,
+		Warnings:   m.warnings,
+		Stats:      m.stats,
+		Skeleton:   m.skeleton,
+		Animations: m.animations,
+
+		TexturesLoaded: m.texturesLoaded,
+	}
+	for _, path := range m.sources {
+		source, err := statSource(path, true)
+		if err != nil {
+			return err
+		}
+		meta.Sources = append(meta.Sources, source)
+	}
+
+	data := make([]byte, modelCacheHeaderSize)
+	addBlob := func(b []byte) cachedBlob {
+		for len(data)%modelCacheAlign != 0 {
+			data = append(data, 0)
+		}
+		offset := len(data)
+		data = append(data, b...)
+		return cachedBlob{Offset: int64(offset), Length: int64(len(b))}
+	}
+
+	for _, texture := range m.textures {
+		cached := cachedTexture{Width: texture.Width, Height: texture.Height, Channels: texture.Channels, SRGB: texture.SRGB}
+		for _, level := range texture.Levels {
+			cached.Levels = append(cached.Levels, addBlob(level))
+		}
+		meta.Textures = append(meta.Textures, cached)
+	}
+	for _, mesh := range m.meshes {
+		// loading rejects them too, there would be no array to point at
+		if len(mesh.vertices) == 0 || len(mesh.indices) == 0 {
+			return errors.New("model has an empty mesh")
+		}
+		cached := cachedMesh{
+			Vertices: addBlob(unsafe.Slice((*byte)(unsafe.Pointer(&mesh.vertices[0])), len(mesh.vertices)*int(unsafe.Sizeof(Vertex{})))),
+			Indices:  addBlob(unsafe.Slice((*byte)(unsafe.Pointer(&mesh.indices[0])), len(mesh.indices)*4)),
+			Material: mesh.material,
+			PBR:      mesh.pbr,
+		}
+		for _, lod := range mesh.lods {
+			cached.LODs = append(cached.LODs, cachedLOD{
+				Indices: addBlob(unsafe.Slice((*byte)(unsafe.Pointer(&lod.indices[0])), len(lod.indices)*4)),
+				Error:   lod.error,
+			})
+		}
+		meta.Meshes = append(meta.Meshes, cached)
+	}
+
+	metaOffset := len(data)
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(&meta); err != nil {
+		return err
+	}
+	data = append(data, encoded.Bytes()...)
+
+	copy(data, modelCacheMagic)
+	binary.LittleEndian.PutUint32(data[8:], modelCacheVersion)
+	binary.NativeEndian.PutUint32(data[12:], 1)
+	binary.LittleEndian.PutUint32(data[16:], uint32(unsafe.Sizeof(Vertex{})))
+	binary.LittleEndian.PutUint32(data[20:], crc32.Checksum(data[modelCacheHeaderSize:], modelCacheTable))
+	binary.LittleEndian.PutUint64(data[24:], uint64(metaOffset))
+
+	if err := os.MkdirAll(modelCacheDir, 0o755); err != nil {
+		return err
+	}
+	// write to a temporary file first so a crash never leaves half a model behind
+	tmp, err := os.CreateTemp(modelCacheDir, key+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(modelCacheDir, key+".bin"))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
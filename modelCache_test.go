@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// cacheTestModel writes a small model with one textured quad to the cache in a temporary
+// directory and returns the model and the path of its cache file.
+func cacheTestModel(t *testing.T) (*Model, string) {
+	dir := t.TempDir()
+	previous := modelCacheDir
+	modelCacheDir = filepath.Join(dir, "cache")
+	t.Cleanup(func() { modelCacheDir = previous })
+
+	source := filepath.Join(dir, "quad.obj")
+	if err := os.WriteFile(source, []byte("# a quad\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := newModel(dir, ModelOptions{})
+	m.source(source)
+	m.warnings = []string{"quad.mtl is missing"}
+	// a 2x2 RGBA texture with its 1x1 mipmap
+	m.textures = []TextureData{{
+		Width: 2, Height: 2, Channels: 4, SRGB: true,
+		Levels: [][]byte{slices.Repeat([]byte{255, 0, 0, 255}, 4), {255, 0, 0, 255}},
+	}}
+	texture := Texture{ID: 1, Type: "texture_diffuse", Path: "red.png"}
+	m.texturesLoaded[texture.Path] = texture
+
+	material := defaultMaterial()
+	material.Textures[0] = texture
+	m.meshes = []Mesh{{
+		vertices: []Vertex{
+			{Position: mgl32.Vec3{-1, -1, 0}, Normal: mgl32.Vec3{0, 0, 1}, TexCoords: mgl32.Vec2{0, 0}},
+			{Position: mgl32.Vec3{1, -1, 0}, Normal: mgl32.Vec3{0, 0, 1}, TexCoords: mgl32.Vec2{1, 0}},
+			{Position: mgl32.Vec3{1, 1, 0}, Normal: mgl32.Vec3{0, 0, 1}, TexCoords: mgl32.Vec2{1, 1}},
+			{Position: mgl32.Vec3{-1, 1, 0}, Normal: mgl32.Vec3{0, 0, 1}, TexCoords: mgl32.Vec2{0, 1}},
+		},
+		indices:  []uint32{0, 1, 2, 0, 2, 3},
+		material: material,
+		lods:     []meshLOD{{indices: []uint32{0, 1, 2}, error: 0.5}},
+	}}
+
+	if err := writeModelCache(m, "quad"); err != nil {
+		t.Fatal(err)
+	}
+	return m, filepath.Join(modelCacheDir, "quad.bin")
+}
+
+func TestModelCacheRoundTrip(t *testing.T) {
+	want, _ := cacheTestModel(t)
+	got, err := loadCachedModel("quad", ModelOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(got.warnings, want.warnings) {
+		t.Errorf("warnings = %v, want %v", got.warnings, want.warnings)
+	}
+	if len(got.textures) != 1 {
+		t.Fatalf("got %v textures, want 1", len(got.textures))
+	}
+	texture := got.textures[0]
+	if texture.Width != 2 || texture.Height != 2 || texture.Channels != 4 || !texture.SRGB {
+		t.Errorf("texture is %vx%v with %v channels, sRGB %v", texture.Width, texture.Height, texture.Channels, texture.SRGB)
+	}
+	if len(texture.Levels) != 2 || !slices.Equal(texture.Levels[0], want.textures[0].Levels[0]) || !slices.Equal(texture.Levels[1], want.textures[0].Levels[1]) {
+		t.Errorf("texture levels = %v, want %v", texture.Levels, want.textures[0].Levels)
+	}
+	if got.texturesLoaded["red.png"] != want.texturesLoaded["red.png"] {
+		t.Errorf("loaded texture = %+v, want %+v", got.texturesLoaded["red.png"], want.texturesLoaded["red.png"])
+	}
+
+	if len(got.meshes) != 1 {
+		t.Fatalf("got %v meshes, want 1", len(got.meshes))
+	}
+	mesh, wantMesh := got.meshes[0], want.meshes[0]
+	if !slices.Equal(mesh.vertices, wantMesh.vertices) {
+		t.Errorf("vertices = %v, want %v", mesh.vertices, wantMesh.vertices)
+	}
+	if !slices.Equal(mesh.indices, wantMesh.indices) {
+		t.Errorf("indices = %v, want %v", mesh.indices, wantMesh.indices)
+	}
+	if mesh.material != wantMesh.material {
+		t.Errorf("material = %+v, want %+v", mesh.material, wantMesh.material)
+	}
+	if len(mesh.lods) != 1 || !slices.Equal(mesh.lods[0].indices, wantMesh.lods[0].indices) || mesh.lods[0].error != wantMesh.lods[0].error {
+		t.Errorf("levels of detail = %+v, want %+v", mesh.lods, wantMesh.lods)
+	}
+	// bounds aren't stored, they are computed again
+	if mesh.bounds.Max != (mgl32.Vec3{1, 1, 0}) {
+		t.Errorf("bounds = %+v", mesh.bounds)
+	}
+}
+
+func TestModelCacheRejectsDamagedFiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		damage func(data []byte)
+		err    string
+	}{
+		{"flipped byte", func(data []byte) { data[len(data)/2] ^= 0xff }, "checksum"},
+		{"wrong checksum", func(data []byte) { binary.LittleEndian.PutUint32(data[20:], 0) }, "checksum"},
+		{"older version", func(data []byte) { binary.LittleEndian.PutUint32(data[8:], modelCacheVersion-1) }, "version"},
+		{"wrong magic", func(data []byte) { data[0] = 'X' }, "not a model cache file"},
+		{"different vertex size", func(data []byte) { binary.LittleEndian.PutUint32(data[16:], 4) }, "different build"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, path := cacheTestModel(t)
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.damage(data)
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = loadCachedModel("quad", ModelOptions{})
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("err = %v, want one about %q", err, test.err)
+			}
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Error("damaged cache file wasn't removed")
+			}
+		})
+	}
+}
+
+func TestModelCacheStaleSource(t *testing.T) {
+	m, path := cacheTestModel(t)
+	if err := os.WriteFile(m.sources[0], []byte("# a bigger quad\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadCachedModel("quad", ModelOptions{}); err == nil {
+		t.Fatal("loaded a model whose source changed")
+	}
+	// stale files are replaced by the next load, not removed
+	if _, err := os.Stat(path); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWriteModelCacheRejectsEmptyMesh(t *testing.T) {
+	m, _ := cacheTestModel(t)
+	m.meshes = append(m.meshes, Mesh{})
+	if err := writeModelCache(m, "empty"); err == nil {
+		t.Fatal("cached a model with an empty mesh")
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// NormalMode picks how normals are generated for meshes that don't have any.
+type NormalMode int
+
+const (
+	// NormalsAuto follows the file: OBJ smoothing groups get smooth normals and faces outside
+	// of one get flat normals. glTF meshes get flat normals, as the spec asks.
+	NormalsAuto NormalMode = iota
+	// NormalsFlat gives every face its own normal.
+	NormalsFlat
+	// NormalsSmooth averages the normals of the faces around a vertex.
+	NormalsSmooth
+)
+
+// smoothNormals reports whether generated normals should be smooth, given whether the file
+// asks for smoothing.
+func (o ModelOptions) smoothNormals(fileSmooth bool) bool {
+	switch o.Normals {
+	case NormalsFlat:
+		return false
+	case NormalsSmooth:
+		return true
+	}
+	return fileSmooth
+}
+
+// generateNormals fills in vertex normals from the faces. Flat normals give every corner the
+// normal of its face. Smooth normals average the faces around a position, weighted by their
+// angle at it, but leave out faces that meet at a sharper angle than creaseAngle degrees so
+// hard edges stay hard. A creaseAngle of 0 or less smooths across every edge.
+//
+// Corners of the same vertex can end up with different normals, so every corner gets its own
+// vertex. Weld them afterwards to share the ones that ended up the same.
+func generateNormals(vertices []Vertex, indices []uint32, smooth bool, creaseAngle float32) ([]Vertex, []uint32) {
+	triangleCount := len(indices) / 3
+	faceNormals := make([]mgl32.Vec3, triangleCount)
+	for t := range faceNormals {
+		a := vertices[indices[3*t]].Position
+		b := vertices[indices[3*t+1]].Position
+		c := vertices[indices[3*t+2]].Position
+		faceNormals[t] = normalizeOrZero(b.Sub(a).Cross(c.Sub(a)))
+	}
+
+	corners := make([]Vertex, triangleCount*3)
+	sequential := make([]uint32, triangleCount*3)
+	for i := range corners {
+		corners[i] = vertices[indices[i]]
+		corners[i].Normal = faceNormals[i/3]
+		sequential[i] = uint32(i)
+	}
+	if !smooth {
+		return corners, sequential
+	}
+
+	// faces are found by position rather than by index, so seams in the texture coordinates
+	// don't show up as seams in the lighting
+	facesAt := make(map[mgl32.Vec3][]int)
+	for i, corner := range corners {
+		facesAt[corner.Position] = append(facesAt[corner.Position], i)
+	}
+
+	minCos := float32(-1)
+	if creaseAngle > 0 {
+		minCos = float32(math.Cos(float64(mgl32.DegToRad(creaseAngle))))
+	}
+	for i := range corners {
+		own := faceNormals[i/3]
+		var sum mgl32.Vec3
+		for _, other := range facesAt[corners[i].Position] {
+			normal := faceNormals[other/3]
+			if other/3 != i/3 && normal.Dot(own) < minCos {
+				continue
+			}
+			sum = sum.Add(normal.Mul(cornerAngle(corners, other)))
+		}
+		if sum.Len() > 0 {
+			corners[i].Normal = sum.Normalize()
+		}
+	}
+	return corners, sequential
+}
+
+// cornerAngle is the angle of a triangle at one of its corners, counting corners across
+// the whole triangle list.
+func cornerAngle(corners []Vertex, corner int) float32 {
+	base := corner / 3 * 3
+	k := corner - base
+	position := corners[corner].Position
+	a := corners[base+(k+1)%3].Position.Sub(position)
+	b := corners[base+(k+2)%3].Position.Sub(position)
+	return angleBetween(a, b)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Ray is a half line from Origin along Direction. Points on it are Origin + t*Direction for
+// t >= 0.
+type Ray struct {
+	Origin    mgl32.Vec3
+	Direction mgl32.Vec3
+}
+
+func (r Ray) at(t float32) mgl32.Vec3 {
+	return r.Origin.Add(r.Direction.Mul(t))
+}
+
+// transform moves the ray by m. The direction isn't normalized afterwards, so distances along
+// the transformed ray are the same as along the original one.
+func (r Ray) transform(m mgl32.Mat4) Ray {
+	return Ray{
+		Origin:    m.Mul4x1(r.Origin.Vec4(1)).Vec3(),
+		Direction: m.Mul4x1(r.Direction.Vec4(0)).Vec3(),
+	}
+}
+
+// RayHit is where a ray hit a triangle of a model.
+type RayHit struct {
+	// Mesh is the index of the mesh in the model, Triangle the triangle in the mesh indices
+	Mesh     int
+	Triangle int
+	// Distance is the t of the hit along the ray
+	Distance float32
+	Point    mgl32.Vec3
+	// Normal is the vertex normals interpolated at the hit, or the face normal for meshes
+	// without normals
+	Normal mgl32.Vec3
+	// Barycentric has the weights of the three corners of the triangle at the hit
+	Barycentric mgl32.Vec3
+}
+
+// intersectRay returns where the ray enters the box, if it does so before tMax. Rays
+// starting inside the box enter at 0.
+func (b AABB) intersectRay(ray Ray, tMax float32) (float32, bool) {
+	tMin := float32(0)
+	for i := 0; i < 3; i++ {
+		if ray.Direction[i] == 0 {
+			// parallel to the slab, inside it or never
+			if ray.Origin[i] < b.Min[i] || ray.Origin[i] > b.Max[i] {
+				return 0, false
+			}
+			continue
+		}
+		inverse := 1 / ray.Direction[i]
+		t0 := (b.Min[i] - ray.Origin[i]) * inverse
+		t1 := (b.Max[i] - ray.Origin[i]) * inverse
+		if t0 > t1 {
+			t0, t1 = t1, t0
+		}
+		tMin = max(tMin, t0)
+		tMax = min(tMax, t1)
+		if tMin > tMax {
+			return 0, false
+		}
+	}
+	return tMin, true
+}
+
+// intersectTriangle is the Möller-Trumbore test. It returns the t of the hit and the
+// barycentric weights of b and c. Both sides of the triangle are hit.
+func intersectTriangle(ray Ray, a, b, c mgl32.Vec3) (t, u, v float32, ok bool) {
+	const epsilon = 1e-8
+	edge1 := b.Sub(a)
+	edge2 := c.Sub(a)
+	p := ray.Direction.Cross(edge2)
+	det := edge1.Dot(p)
+	if math.Abs(float64(det)) < epsilon {
+		// the ray is parallel to the triangle
+		return 0, 0, 0, false
+	}
+	inverse := 1 / det
+	s := ray.Origin.Sub(a)
+	u = s.Dot(p) * inverse
+	if u < 0 || u > 1 {
+		return 0, 0, 0, false
+	}
+	q := s.Cross(edge1)
+	v = ray.Direction.Dot(q) * inverse
+	if v < 0 || u+v > 1 {
+		return 0, 0, 0, false
+	}
+	t = edge2.Dot(q) * inverse
+	return t, u, v, t >= 0
+}
+
+// bvhLeafSize is the most triangles a BVH leaf holds.
+const bvhLeafSize = 4
+
+// bvhNode is a box in the BVH. Leaves hold count triangles from start in BVH.triangles,
+// inner nodes have a count of 0 and their children at left and left+1.
+type bvhNode struct {
+	bounds AABB
+	left   int
+	start  int
+	count  int
+}
+
+// BVH is a bounding volume hierarchy over the triangles of a mesh, to find the triangles a
+// ray hits without testing all of them.
+type BVH struct {
+	nodes []bvhNode
+	// triangles are the triangle numbers, ordered so every leaf has a run of them
+	triangles []int
+}
+
+// buildBVH splits the triangles in half along the longest axis of their centers until the
+// halves are small enough for a leaf.
+func buildBVH(vertices []Vertex, indices []uint32) *BVH {
+	triangleCount := len(indices) / 3
+	b := &BVH{triangles: make([]int, triangleCount)}
+	bounds := make([]AABB, triangleCount)
+	centers := make([]mgl32.Vec3, triangleCount)
+	for t := range b.triangles {
+		b.triangles[t] = t
+		box := AABB{Min: vertices[indices[3*t]].Position, Max: vertices[indices[3*t]].Position}
+		box = box.extend(vertices[indices[3*t+1]].Position).extend(vertices[indices[3*t+2]].Position)
+		bounds[t] = box
+		centers[t] = box.center()
+	}
+	if triangleCount == 0 {
+		return b
+	}
+
+	b.nodes = append(b.nodes, bvhNode{start: 0, count: triangleCount})
+	// nodes still to be split
+	stack := []int{0}
+	for len(stack) > 0 {
+		index := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		node := &b.nodes[index]
+
+		triangles := b.triangles[node.start : node.start+node.count]
+		node.bounds = bounds[triangles[0]]
+		centerBounds := AABB{Min: centers[triangles[0]], Max: centers[triangles[0]]}
+		for _, t := range triangles[1:] {
+			node.bounds = node.bounds.union(bounds[t])
+			centerBounds = centerBounds.extend(centers[t])
+		}
+		if node.count <= bvhLeafSize {
+			continue
+		}
+
+		size := centerBounds.Max.Sub(centerBounds.Min)
+		axis := 0
+		if size[1] > size[axis] {
+			axis = 1
+		}
+		if size[2] > size[axis] {
+			axis = 2
+		}
+		sort.Slice(triangles, func(i, j int) bool {
+			return centers[triangles[i]][axis] < centers[triangles[j]][axis]
+		})
+
+		half := node.count / 2
+		left := len(b.nodes)
+		start, count := node.start, node.count
+		node.left = left
+		node.count = 0
+		// node isn't valid after the append
+		b.nodes = append(b.nodes,
+			bvhNode{start: start, count: half},
+			bvhNode{start: start + half, count: count - half},
+		)
+		stack = append(stack, left, left+1)
+	}
+	return b
+}
+
+// intersect returns the closest hit of the ray with the triangles. Mesh is left at 0.
+func (b *BVH) intersect(ray Ray, vertices []Vertex, indices []uint32) (RayHit, bool) {
+	var hit RayHit
+	found := false
+	closest := float32(math.MaxFloat32)
+	if len(b.nodes) == 0 {
+		return hit, false
+	}
+
+	stack := []int{0}
+	for len(stack) > 0 {
+		node := b.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+		if _, ok := node.bounds.intersectRay(ray, closest); !ok {
+			continue
+		}
+		if node.count == 0 {
+			stack = append(stack, node.left, node.left+1)
+			continue
+		}
+
+		for _, t := range b.triangles[node.start : node.start+node.count] {
+			v0, v1, v2 := vertices[indices[3*t]], vertices[indices[3*t+1]], vertices[indices[3*t+2]]
+			distance, u, v, ok := intersectTriangle(ray, v0.Position, v1.Position, v2.Position)
+			if !ok || distance >= closest {
+				continue
+			}
+			closest = distance
+			found = true
+
+			barycentric := mgl32.Vec3{1 - u - v, u, v}
+			normal := v0.Normal.Mul(barycentric[0]).Add(v1.Normal.Mul(barycentric[1])).Add(v2.Normal.Mul(barycentric[2]))
+			if normal.Len() == 0 {
+				normal = v1.Position.Sub(v0.Position).Cross(v2.Position.Sub(v0.Position))
+			}
+			hit = RayHit{
+				Triangle:    t,
+				Distance:    distance,
+				Point:       ray.at(distance),
+				Normal:      normalizeOrZero(normal),
+				Barycentric: barycentric,
+			}
+		}
+	}
+	return hit, found
+}
+
+// Raycast returns the closest triangle of the model hit by a ray in model space. Use
+// Ray.transform with the inverse model matrix to pick with a world space ray.
+//
+// The BVH of a mesh is built the first time a ray reaches its bounds.
+func (m *Model) Raycast(ray Ray) (RayHit, bool) {
+	var hit RayHit
+	found := false
+	closest := float32(math.MaxFloat32)
+	for i := range m.meshes {
+		mesh := &m.meshes[i]
+		if _, ok := mesh.bounds.intersectRay(ray, closest); !ok {
+			continue
+		}
+		if mesh.bvh == nil {
+			mesh.bvh = buildBVH(mesh.vertices, mesh.indices)
+		}
+		meshHit, ok := mesh.bvh.intersect(ray, mesh.vertices, mesh.indices)
+		if !ok || meshHit.Distance >= closest {
+			continue
+		}
+		meshHit.Mesh = i
+		hit = meshHit
+		closest = meshHit.Distance
+		found = true
+	}
+	return hit, found
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+func TestAABBIntersectRay(t *testing.T) {
+	box := AABB{Min: mgl32.Vec3{-1, -1, -1}, Max: mgl32.Vec3{1, 1, 1}}
+	tests := []struct {
+		name  string
+		ray   Ray
+		tMax  float32
+		hit   bool
+		enter float32
+	}{
+		{"straight on", Ray{mgl32.Vec3{0, 0, 5}, mgl32.Vec3{0, 0, -1}}, 100, true, 4},
+		{"unnormalized direction", Ray{mgl32.Vec3{0, 0, 5}, mgl32.Vec3{0, 0, -2}}, 100, true, 2},
+		{"diagonal", Ray{mgl32.Vec3{3, 3, 0}, mgl32.Vec3{-1, -1, 0}}, 100, true, 2},
+		{"from inside", Ray{mgl32.Vec3{0, 0, 0}, mgl32.Vec3{1, 0, 0}}, 100, true, 0},
+		{"pointing away", Ray{mgl32.Vec3{0, 0, 5}, mgl32.Vec3{0, 0, 1}}, 100, false, 0},
+		{"passing by", Ray{mgl32.Vec3{2, 0, 5}, mgl32.Vec3{0, 0, -1}}, 100, false, 0},
+		{"parallel inside the slab", Ray{mgl32.Vec3{0.5, -5, 0}, mgl32.Vec3{0, 1, 0}}, 100, true, 4},
+		{"parallel outside the slab", Ray{mgl32.Vec3{1.5, -5, 0}, mgl32.Vec3{0, 1, 0}}, 100, false, 0},
+		{"stops short", Ray{mgl32.Vec3{0, 0, 5}, mgl32.Vec3{0, 0, -1}}, 3, false, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			enter, hit := box.intersectRay(test.ray, test.tMax)
+			if hit != test.hit {
+				t.Fatalf("hit = %v, want %v", hit, test.hit)
+			}
+			if hit && math.Abs(float64(enter-test.enter)) > 1e-5 {
+				t.Errorf("enters at %v, want %v", enter, test.enter)
+			}
+		})
+	}
+}
+
+func TestIntersectTriangle(t *testing.T) {
+	// counter-clockwise seen from +z
+	a, b, c := mgl32.Vec3{0, 0, 0}, mgl32.Vec3{1, 0, 0}, mgl32.Vec3{0, 1, 0}
+	tests := []struct {
+		name     string
+		ray      Ray
+		hit      bool
+		distance float32
+		u, v     float32
+	}{
+		{"front face", Ray{mgl32.Vec3{0.25, 0.25, 1}, mgl32.Vec3{0, 0, -1}}, true, 1, 0.25, 0.25},
+		{"back face", Ray{mgl32.Vec3{0.25, 0.25, -2}, mgl32.Vec3{0, 0, 1}}, true, 2, 0.25, 0.25},
+		{"corner", Ray{mgl32.Vec3{1, 0, 1}, mgl32.Vec3{0, 0, -1}}, true, 1, 1, 0},
+		{"outside the edge", Ray{mgl32.Vec3{0.6, 0.6, 1}, mgl32.Vec3{0, 0, -1}}, false, 0, 0, 0},
+		{"outside a corner", Ray{mgl32.Vec3{-0.1, 0.5, 1}, mgl32.Vec3{0, 0, -1}}, false, 0, 0, 0},
+		{"behind the origin", Ray{mgl32.Vec3{0.25, 0.25, -1}, mgl32.Vec3{0, 0, -1}}, false, 0, 0, 0},
+		{"parallel", Ray{mgl32.Vec3{-1, 0.25, 0}, mgl32.Vec3{1, 0, 0}}, false, 0, 0, 0},
+		{"parallel above", Ray{mgl32.Vec3{-1, 0.25, 1}, mgl32.Vec3{1, 0, 0}}, false, 0, 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			distance, u, v, hit := intersectTriangle(test.ray, a, b, c)
+			if hit != test.hit {
+				t.Fatalf("hit = %v, want %v", hit, test.hit)
+			}
+			if !hit {
+				return
+			}
+			if math.Abs(float64(distance-test.distance)) > 1e-5 {
+				t.Errorf("distance = %v, want %v", distance, test.distance)
+			}
+			if math.Abs(float64(u-test.u)) > 1e-5 || math.Abs(float64(v-test.v)) > 1e-5 {
+				t.Errorf("barycentric = %v, %v, want %v, %v", u, v, test.u, test.v)
+			}
+		})
+	}
+}
+
+// randomTriangles returns a soup of small triangles scattered through a cube.
+func randomTriangles(r *rand.Rand, count int) ([]Vertex, []uint32) {
+	point := func(scale float32) mgl32.Vec3 {
+		return mgl32.Vec3{r.Float32()*2 - 1, r.Float32()*2 - 1, r.Float32()*2 - 1}.Mul(scale)
+	}
+	var vertices []Vertex
+	var indices []uint32
+	for i := 0; i < count; i++ {
+		center := point(5)
+		for j := 0; j < 3; j++ {
+			vertices = append(vertices, Vertex{Position: center.Add(point(0.5))})
+			indices = append(indices, uint32(len(vertices)-1))
+		}
+	}
+	return vertices, indices
+}
+
+// bruteForce tests the ray against every triangle.
+func bruteForce(ray Ray, vertices []Vertex, indices []uint32) (int, float32, bool) {
+	closest := float32(math.MaxFloat32)
+	triangle := -1
+	for tri := 0; tri < len(indices)/3; tri++ {
+		distance, _, _, ok := intersectTriangle(ray, vertices[indices[3*tri]].Position, vertices[indices[3*tri+1]].Position, vertices[indices[3*tri+2]].Position)
+		if ok && distance < closest {
+			closest = distance
+			triangle = tri
+		}
+	}
+	return triangle, closest, triangle >= 0
+}
+
+func TestBVHMatchesBruteForce(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	vertices, indices := randomTriangles(r, 500)
+	bvh := buildBVH(vertices, indices)
+	hits := 0
+	for i := 0; i < 1000; i++ {
+		// from anywhere around the triangles, towards somewhere among them
+		origin := mgl32.Vec3{r.Float32()*20 - 10, r.Float32()*20 - 10, r.Float32()*20 - 10}
+		target := mgl32.Vec3{r.Float32()*10 - 5, r.Float32()*10 - 5, r.Float32()*10 - 5}
+		ray := Ray{Origin: origin, Direction: target.Sub(origin)}
+		wantTriangle, wantDistance, wantHit := bruteForce(ray, vertices, indices)
+		hit, ok := bvh.intersect(ray, vertices, indices)
+		if ok != wantHit {
+			t.Fatalf("ray %v: hit = %v, want %v", i, ok, wantHit)
+		}
+		if !ok {
+			continue
+		}
+		hits++
+		// ties between triangles at the same distance may go either way
+		if hit.Triangle != wantTriangle && hit.Distance != wantDistance {
+			t.Fatalf("ray %v: hit triangle %v at %v, want %v at %v", i, hit.Triangle, hit.Distance, wantTriangle, wantDistance)
+		}
+	}
+	if hits == 0 {
+		t.Fatal("no ray hit anything")
+	}
+}
+
+func TestModelRaycastWithTransform(t *testing.T) {
+	// a quad facing +z, with a triangle further back in the first mesh
+	quad := Mesh{
+		vertices: []Vertex{
+			{Position: mgl32.Vec3{-1, -1, 0}, Normal: mgl32.Vec3{0, 0, 1}},
+			{Position: mgl32.Vec3{1, -1, 0}, Normal: mgl32.Vec3{0, 0, 1}},
+			{Position: mgl32.Vec3{1, 1, 0}, Normal: mgl32.Vec3{0, 0, 1}},
+			{Position: mgl32.Vec3{-1, 1, 0}, Normal: mgl32.Vec3{0, 0, 1}},
+		},
+		indices: []uint32{0, 1, 2, 0, 2, 3},
+	}
+	quad.computeBounds()
+	far := Mesh{
+		vertices: []Vertex{
+			{Position: mgl32.Vec3{-1, -1, -5}},
+			{Position: mgl32.Vec3{1, -1, -5}},
+			{Position: mgl32.Vec3{0, 1, -5}},
+		},
+		indices: []uint32{0, 1, 2},
+	}
+	far.computeBounds()
+	m := &Model{meshes: []Mesh{far, quad}}
+
+	// the model is moved to x = 10 and scaled up twice
+	transform := mgl32.Translate3D(10, 0, 0).Mul4(mgl32.Scale3D(2, 2, 2))
+	inverse := transform.Inv()
+
+	tests := []struct {
+		name  string
+		ray   Ray
+		hit   bool
+		mesh  int
+		point mgl32.Vec3
+		// distance along the world ray
+		distance float32
+	}{
+		{"quad center", Ray{mgl32.Vec3{10, 0, 10}, mgl32.Vec3{0, 0, -1}}, true, 1, mgl32.Vec3{0, 0, 0}, 10},
+		{"scaled edge", Ray{mgl32.Vec3{11.5, 1.5, 10}, mgl32.Vec3{0, 0, -1}}, true, 1, mgl32.Vec3{0.75, 0.75, 0}, 10},
+		{"where the model was", Ray{mgl32.Vec3{0, 0, 10}, mgl32.Vec3{0, 0, -1}}, false, 0, mgl32.Vec3{}, 0},
+		{"from behind", Ray{mgl32.Vec3{10, 0, -20}, mgl32.Vec3{0, 0, 1}}, true, 0, mgl32.Vec3{0, 0, -5}, 10},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hit, ok := m.Raycast(test.ray.transform(inverse))
+			if ok != test.hit {
+				t.Fatalf("hit = %v, want %v", ok, test.hit)
+			}
+			if !ok {
+				return
+			}
+			if hit.Mesh != test.mesh {
+				t.Errorf("hit mesh %v, want %v", hit.Mesh, test.mesh)
+			}
+			if hit.Point.Sub(test.point).Len() > 1e-4 {
+				t.Errorf("hit at %v, want %v", hit.Point, test.point)
+			}
+			// the direction isn't normalized by the transform, so distances stay in world units
+			if math.Abs(float64(hit.Distance-test.distance)) > 1e-4 {
+				t.Errorf("distance = %v, want %v", hit.Distance, test.distance)
+			}
+		})
+	}
+}
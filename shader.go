@@ -3,126 +3,369 @@ package main
 import (
 	"fmt"
 	"os"
-	"unsafe"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-gl/gl/v4.1-core/gl"
 	"github.com/go-gl/mathgl/mgl32"
+
+	"github.com/braheezy/learn-opengl/internal/shadercache"
 )
 
 type Shader struct {
-	id uint32
+	id      uint32
+	stages  []shaderStage
+	defines map[string]string
+	// hot reload state, see watch()
+	modTimes map[string]time.Time
+	lastPoll time.Time
+	values   map[string]uniformValue
+	// cached program interface, see reflect()
+	uniforms    map[string]UniformInfo
+	uniformList []UniformInfo
+	attributes  map[string]AttributeInfo
+	warned      map[string]bool
+}
+
+// uniformValue is a uniform value remembered for a hot reload, see Shader.set.
+type uniformValue struct {
+	xtype uint32
+	apply func(location int32)
+}
+
+// shaderStage is a single stage of a shader program along with the file it came from.
+type shaderStage struct {
+	kind   uint32
+	name   string
+	path   string
+	source string
+	// origin of every line in source and all files it was built from, see preprocessShader
+	lines []sourceLine
+	files []string
+}
+
+// ShaderError is returned when a shader stage fails to compile or a program fails to link.
+type ShaderError struct {
+	// Stage is VERTEX, TESS_CONTROL, TESS_EVALUATION, GEOMETRY, FRAGMENT or PROGRAM for
+	// link errors
+	Stage string
+	// Path is the source file of the stage. It is empty for link errors.
+	Path string
+	// Log is the complete info log reported by the driver
+	Log string
+	// Diagnostics are the messages of Log that could be mapped back to a source line
+	Diagnostics []ShaderDiagnostic
+}
+
+// ShaderDiagnostic is a single compiler message with its location in the source file.
+type ShaderDiagnostic struct {
+	Path    string
+	Line    int
+	Message string
+}
+
+func (e *ShaderError) Error() string {
+	var b strings.Builder
+	if e.Stage == "PROGRAM" {
+		b.WriteString("failed to link shader program")
+	} else {
+		fmt.Fprintf(&b, "failed to compile %v shader", e.Stage)
+		if e.Path != "" {
+			fmt.Fprintf(&b, " %v", e.Path)
+		}
+	}
+	if len(e.Diagnostics) == 0 {
+		fmt.Fprintf(&b, ": %v", strings.TrimSpace(e.Log))
+		return b.String()
+	}
+	b.WriteString(":")
+	for _, d := range e.Diagnostics {
+		fmt.Fprintf(&b, "\n%v", d)
+	}
+	return b.String()
+}
+
+func (d ShaderDiagnostic) String() string {
+	return fmt.Sprintf("%v:%v: %v", d.Path, d.Line, d.Message)
+}
+
+// ShaderSources names the source file of every stage of a shader program. Vertex and
+// Fragment are required, the other stages are optional.
+type ShaderSources struct {
+	Vertex         string
+	TessControl    string
+	TessEvaluation string
+	Geometry       string
+	Fragment       string
+	// Defines adds a #define for every entry to each stage
+	Defines map[string]string
+}
+
+// stages lists the stages in pipeline order, skipping the ones without a source file.
+func (sources ShaderSources) stages() ([]shaderStage, error) {
+	if sources.Vertex == "" || sources.Fragment == "" {
+		return nil, fmt.Errorf("shader program needs a vertex and a fragment shader")
+	}
+	if sources.TessControl != "" && sources.TessEvaluation == "" {
+		return nil, fmt.Errorf("tessellation control shader %v needs a tessellation evaluation shader", sources.TessControl)
+	}
+
+	all := []shaderStage{
+		{kind: gl.VERTEX_SHADER, name: "VERTEX", path: sources.Vertex},
+		{kind: gl.TESS_CONTROL_SHADER, name: "TESS_CONTROL", path: sources.TessControl},
+		{kind: gl.TESS_EVALUATION_SHADER, name: "TESS_EVALUATION", path: sources.TessEvaluation},
+		{kind: gl.GEOMETRY_SHADER, name: "GEOMETRY", path: sources.Geometry},
+		{kind: gl.FRAGMENT_SHADER, name: "FRAGMENT", path: sources.Fragment},
+	}
+	var stages []shaderStage
+	for _, stage := range all {
+		if stage.path != "" {
+			stages = append(stages, stage)
+		}
+	}
+	return stages, nil
 }
 
 func NewShader(vertexPath string, fragmentPath string, geometryPath string) (*Shader, error) {
-	// Read shader programs from disk.
-	data, err := os.ReadFile(vertexPath)
+	return NewShaderWithDefines(vertexPath, fragmentPath, geometryPath, nil)
+}
+
+// NewShaderWithDefines builds a shader like NewShader, adding a #define for every entry of
+// defines to each stage. Sources may #include other files relative to their own directory.
+func NewShaderWithDefines(vertexPath string, fragmentPath string, geometryPath string, defines map[string]string) (*Shader, error) {
+	return NewShaderFromSources(ShaderSources{
+		Vertex:   vertexPath,
+		Geometry: geometryPath,
+		Fragment: fragmentPath,
+		Defines:  defines,
+	})
+}
+
+// NewShaderFromSources builds a shader program from any combination of stages, including
+// the tessellation stages.
+func NewShaderFromSources(sources ShaderSources) (*Shader, error) {
+	stages, err := sources.stages()
 	if err != nil {
 		return nil, err
 	}
-	vertexShaderSource := string(data)
-	data, err = os.ReadFile(fragmentPath)
+
+	// Read shader programs from disk.
+	for i := range stages {
+		if err := stages[i].load(sources.Defines, os.ReadFile); err != nil {
+			return nil, err
+		}
+	}
+
+	id, err := linkProgram(stages)
 	if err != nil {
 		return nil, err
 	}
-	fragmentShaderSource := string(data)
+	s := &Shader{id: id, stages: stages, defines: sources.Defines}
+	s.reflect()
+	uniformBlockShaders = append(uniformBlockShaders, s)
+	return s, nil
+}
 
-	var geometryShader uint32
+// linkProgram compiles every stage and links them together to form a shader program, which
+// is used during rendering.
+func linkProgram(stages []shaderStage) (uint32, error) {
+	// Skip compiling if this exact program was linked before
+	useCache := shadercache.Dir != "" && shadercache.Supported()
+	var cacheKey string
+	if useCache {
+		cacheKey = programCacheKey(stages)
+		if ID, ok := shadercache.Load(cacheKey); ok {
+			return ID, nil
+		}
+	}
 
-	if geometryPath != "" {
-		data, err = os.ReadFile(geometryPath)
-		if err != nil {
-			return nil, err
+	shaders := make([]uint32, 0, len(stages))
+	// Clean up shader objects, they are not needed once the program is linked
+	defer func() {
+		for _, shader := range shaders {
+			gl.DeleteShader(shader)
 		}
-		geometryShaderSource := string(data)
+	}()
 
-		geometryShader = gl.CreateShader(gl.GEOMETRY_SHADER)
-		sourceString, geometryFreeFunc := gl.Strs(geometryShaderSource, "\x00")
-		defer geometryFreeFunc()
-		gl.ShaderSource(geometryShader, 1, sourceString, nil)
-		gl.CompileShader(geometryShader)
-		checkCompile(geometryShader, "GEOMETRY")
+	for _, stage := range stages {
+		shader, err := compileShader(stage)
+		if err != nil {
+			return 0, err
+		}
+		shaders = append(shaders, shader)
 	}
 
-	// Create a vertex shader object ID
-	vertexShader := gl.CreateShader(gl.VERTEX_SHADER)
-	// Put the shader source code into the vertex shader. It must be a null-terminated string
-	// in C flavor.
-	sourceString, vertexFreeFunc := gl.Strs(vertexShaderSource, "\x00")
-	defer vertexFreeFunc()
-	gl.ShaderSource(vertexShader, 1, sourceString, nil)
-	// Compile the shader
-	gl.CompileShader(vertexShader)
-
-	// The next shader is the fragment shader, concerned with calculating color output
-	// for each pixel.
-	fragmentShader := gl.CreateShader(gl.FRAGMENT_SHADER)
-	sourceString, fragmentFreeFunc := gl.Strs(fragmentShaderSource, "\x00")
-	defer fragmentFreeFunc()
-	gl.ShaderSource(fragmentShader, 1, sourceString, nil)
-	gl.CompileShader(fragmentShader)
-
-	// Check shader compilation.
-	checkCompile(fragmentShader, "VERTEX")
-	checkCompile(fragmentShader, "FRAGMENT")
-
-	// Link all shaders together to form a shader program, which is used during rendering.
 	ID := gl.CreateProgram()
-	gl.AttachShader(ID, vertexShader)
-	gl.AttachShader(ID, fragmentShader)
-	if geometryPath != "" {
-		gl.AttachShader(ID, geometryShader)
+	for _, shader := range shaders {
+		gl.AttachShader(ID, shader)
+	}
+	if useCache {
+		gl.ProgramParameteri(ID, gl.PROGRAM_BINARY_RETRIEVABLE_HINT, gl.TRUE)
 	}
 	gl.LinkProgram(ID)
 
 	// Check program linking
-	checkCompile(ID, "PROGRAM")
+	if err := checkLink(ID); err != nil {
+		gl.DeleteProgram(ID)
+		return 0, err
+	}
+	if useCache {
+		shadercache.Store(ID, cacheKey)
+	}
+	return ID, nil
+}
 
-	// Clean up shader objects
-	gl.DeleteShader(vertexShader)
-	gl.DeleteShader(fragmentShader)
-	if geometryPath != "" {
-		gl.DeleteShader(geometryShader)
+// programCacheKey returns the key of the program the stages link to in the program binary
+// cache.
+func programCacheKey(stages []shaderStage) string {
+	keyed := make([]shadercache.Stage, len(stages))
+	for i, stage := range stages {
+		keyed[i] = shadercache.Stage{Kind: stage.kind, Source: stage.source}
 	}
+	return shadercache.Key(keyed)
+}
+
+func compileShader(stage shaderStage) (uint32, error) {
+	shader := gl.CreateShader(stage.kind)
+	// Put the shader source code into the shader. It must be a null-terminated string
+	// in C flavor.
+	sourceString, freeFunc := gl.Strs(stage.source + "\x00")
+	defer freeFunc()
+	gl.ShaderSource(shader, 1, sourceString, nil)
+	gl.CompileShader(shader)
 
-	return &Shader{id: ID}, nil
+	if err := checkCompile(shader, stage); err != nil {
+		gl.DeleteShader(shader)
+		return 0, err
+	}
+	return shader, nil
 }
 
-func checkCompile(shader uint32, shaderType string) error {
+func checkCompile(shader uint32, stage shaderStage) error {
 	var success int32
-	infoLog := make([]uint8, 512)
 	gl.GetShaderiv(shader, gl.COMPILE_STATUS, &success)
-	if success == gl.FALSE {
-		gl.GetShaderInfoLog(shader, 512, nil, (*uint8)(unsafe.Pointer(&infoLog)))
-		return fmt.Errorf("failed to compile %v shader: %v", shaderType, string(infoLog))
+	if success == gl.TRUE {
+		return nil
+	}
+
+	var logLength int32
+	gl.GetShaderiv(shader, gl.INFO_LOG_LENGTH, &logLength)
+	infoLog := make([]uint8, logLength+1)
+	gl.GetShaderInfoLog(shader, logLength, nil, &infoLog[0])
+
+	logText := gl.GoStr(&infoLog[0])
+	return &ShaderError{
+		Stage:       stage.name,
+		Path:        stage.path,
+		Log:         logText,
+		Diagnostics: parseInfoLog(logText, stage),
 	}
-	return nil
+}
+
+func checkLink(program uint32) error {
+	var success int32
+	gl.GetProgramiv(program, gl.LINK_STATUS, &success)
+	if success == gl.TRUE {
+		return nil
+	}
+
+	var logLength int32
+	gl.GetProgramiv(program, gl.INFO_LOG_LENGTH, &logLength)
+	infoLog := make([]uint8, logLength+1)
+	gl.GetProgramInfoLog(program, logLength, nil, &infoLog[0])
+
+	return &ShaderError{Stage: "PROGRAM", Log: gl.GoStr(&infoLog[0])}
+}
+
+// Drivers don't agree on an info log format. These cover the common ones:
+//
+//	0:12(5): error: ...           (Mesa)
+//	ERROR: 0:12: ...              (AMD, Apple, ANGLE)
+//	0(12) : error C0000: ...      (NVIDIA)
+var infoLogPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^\d+:(\d+)\(\d+\): (.*)$`),
+	regexp.MustCompile(`^((?:ERROR|WARNING): )\d+:(\d+): (.*)$`),
+	regexp.MustCompile(`^\d+\((\d+)\) ?: (.*)$`),
+}
+
+// parseInfoLog maps every line of a compile log that carries a line number back to the
+// file and line it came from before preprocessing.
+func parseInfoLog(logText string, stage shaderStage) []ShaderDiagnostic {
+	var diagnostics []ShaderDiagnostic
+	for _, line := range strings.Split(logText, "\n") {
+		line = strings.TrimSpace(line)
+		for _, pattern := range infoLogPatterns {
+			match := pattern.FindStringSubmatch(line)
+			if match == nil {
+				continue
+			}
+			// the prefixed form keeps its severity with the message
+			prefix := ""
+			if len(match) == 4 {
+				prefix = strings.ToLower(match[1])
+				match = append(match[:1], match[2:]...)
+			}
+			lineNumber, _ := strconv.Atoi(match[1])
+			path, sourceLineNumber := stage.location(lineNumber)
+			diagnostics = append(diagnostics, ShaderDiagnostic{
+				Path:    path,
+				Line:    sourceLineNumber,
+				Message: prefix + match[2],
+			})
+			break
+		}
+	}
+	return diagnostics
 }
 
 func (s *Shader) use() {
 	gl.UseProgram(s.id)
 }
 
+// set applies a uniform value of type xtype to the program. Watched shaders remember the value
+// so it survives a reload.
+func (s *Shader) set(name string, xtype uint32, apply func(location int32)) {
+	apply(s.uniformLocation(name, xtype))
+	if s.values != nil {
+		s.values[name] = uniformValue{xtype: xtype, apply: apply}
+	}
+}
+
 func (s *Shader) setBool(name string, value bool) {
 	var v0 int32
 	if value {
 		v0 = 1
 	}
-	gl.Uniform1i(gl.GetUniformLocation(s.id, gl.Str(name+"\x00")), v0)
+	s.set(name, gl.INT, func(location int32) { gl.Uniform1i(location, v0) })
 }
 
 func (s *Shader) setInt(name string, value int32) {
-	gl.Uniform1i(gl.GetUniformLocation(s.id, gl.Str(name+"\x00")), value)
+	s.set(name, gl.INT, func(location int32) { gl.Uniform1i(location, value) })
 }
 
 func (s *Shader) setFloat(name string, value float32) {
-	gl.Uniform1f(gl.GetUniformLocation(s.id, gl.Str(name+"\x00")), value)
+	s.set(name, gl.FLOAT, func(location int32) { gl.Uniform1f(location, value) })
 }
 func (s *Shader) setMat3(name string, value mgl32.Mat3) {
-	gl.UniformMatrix3fv(gl.GetUniformLocation(s.id, gl.Str(name+"\x00")), 1, false, &value[0])
+	s.set(name, gl.FLOAT_MAT3, func(location int32) { gl.UniformMatrix3fv(location, 1, false, &value[0]) })
 }
 func (s *Shader) setMat4(name string, value mgl32.Mat4) {
-	gl.UniformMatrix4fv(gl.GetUniformLocation(s.id, gl.Str(name+"\x00")), 1, false, &value[0])
+	s.set(name, gl.FLOAT_MAT4, func(location int32) { gl.UniformMatrix4fv(location, 1, false, &value[0]) })
+}
+
+// setMat4Array sets a mat4 array uniform, starting at its first element. An empty array
+// sets nothing.
+func (s *Shader) setMat4Array(name string, values []mgl32.Mat4) {
+	if len(values) == 0 {
+		return
+	}
+	s.set(name, gl.FLOAT_MAT4, func(location int32) { gl.UniformMatrix4fv(location, int32(len(values)), false, &values[0][0]) })
 }
 func (s *Shader) setVec3(name string, value mgl32.Vec3) {
-	gl.Uniform3fv(gl.GetUniformLocation(s.id, gl.Str(name+"\x00")), 1, &value[0])
+	s.set(name, gl.FLOAT_VEC3, func(location int32) { gl.Uniform3fv(location, 1, &value[0]) })
+}
+func (s *Shader) setVec4(name string, value mgl32.Vec4) {
+	s.set(name, gl.FLOAT_VEC4, func(location int32) { gl.Uniform4fv(location, 1, &value[0]) })
 }
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// UniformInfo describes an active uniform of a linked program.
+type UniformInfo struct {
+	Name     string
+	Location int32
+	// Type is the GLSL type, like gl.FLOAT_VEC3 or gl.SAMPLER_2D
+	Type uint32
+	// Size is the number of array elements, 1 for uniforms that aren't arrays
+	Size int32
+}
+
+// AttributeInfo describes an active vertex attribute of a linked program.
+type AttributeInfo struct {
+	Name     string
+	Location int32
+	Type     uint32
+	Size     int32
+}
+
+func (u UniformInfo) String() string {
+	if u.Size > 1 {
+		return fmt.Sprintf("%v %v[%v] (location %v)", glslTypeName(u.Type), u.Name, u.Size, u.Location)
+	}
+	return fmt.Sprintf("%v %v (location %v)", glslTypeName(u.Type), u.Name, u.Location)
+}
+
+func (a AttributeInfo) String() string {
+	return fmt.Sprintf("%v %v (location %v)", glslTypeName(a.Type), a.Name, a.Location)
+}
+
+// Uniforms lists the active uniforms of the program sorted by name. Uniforms that live in a
+// uniform block have no location and aren't included.
+func (s *Shader) Uniforms() []UniformInfo {
+	uniforms := make([]UniformInfo, 0, len(s.uniformList))
+	uniforms = append(uniforms, s.uniformList...)
+	return uniforms
+}
+
+// Attributes lists the active vertex attributes of the program sorted by location.
+func (s *Shader) Attributes() []AttributeInfo {
+	attributes := make([]AttributeInfo, 0, len(s.attributes))
+	for _, attribute := range s.attributes {
+		attributes = append(attributes, attribute)
+	}
+	sort.Slice(attributes, func(i, j int) bool { return attributes[i].Location < attributes[j].Location })
+	return attributes
+}
+
+// reflect asks the linked program for its active uniforms and attributes and caches their
+// locations, so setters don't have to look them up by name every frame.
+func (s *Shader) reflect() {
+	s.uniforms = make(map[string]UniformInfo)
+	s.uniformList = nil
+	s.attributes = make(map[string]AttributeInfo)
+	// made here rather than on the first warning, so copies of the Shader share it
+	if s.warned == nil {
+		s.warned = make(map[string]bool)
+	}
+
+	var count, maxLength int32
+	gl.GetProgramiv(s.id, gl.ACTIVE_UNIFORMS, &count)
+	gl.GetProgramiv(s.id, gl.ACTIVE_UNIFORM_MAX_LENGTH, &maxLength)
+	buf := make([]uint8, maxLength+1)
+	for i := int32(0); i < count; i++ {
+		var length, size int32
+		var xtype uint32
+		gl.GetActiveUniform(s.id, uint32(i), maxLength+1, &length, &size, &xtype, &buf[0])
+		name := string(buf[:length])
+		location := gl.GetUniformLocation(s.id, gl.Str(name+"\x00"))
+		if location < 0 {
+			// part of a uniform block
+			continue
+		}
+
+		// arrays are reported as their first element, "offsets[0]"
+		isArray := strings.HasSuffix(name, "[0]")
+		name = strings.TrimSuffix(name, "[0]")
+		info := UniformInfo{Name: name, Location: location, Type: xtype, Size: size}
+		s.uniforms[name] = info
+		s.uniformList = append(s.uniformList, info)
+		if isArray {
+			for element := int32(0); element < size; element++ {
+				elementName := fmt.Sprintf("%v[%v]", name, element)
+				s.uniforms[elementName] = UniformInfo{
+					Name:     elementName,
+					Location: gl.GetUniformLocation(s.id, gl.Str(elementName+"\x00")),
+					Type:     xtype,
+					Size:     1,
+				}
+			}
+		}
+	}
+	sort.Slice(s.uniformList, func(i, j int) bool { return s.uniformList[i].Name < s.uniformList[j].Name })
+
+	gl.GetProgramiv(s.id, gl.ACTIVE_ATTRIBUTES, &count)
+	gl.GetProgramiv(s.id, gl.ACTIVE_ATTRIBUTE_MAX_LENGTH, &maxLength)
+	buf = make([]uint8, maxLength+1)
+	for i := int32(0); i < count; i++ {
+		var length, size int32
+		var xtype uint32
+		gl.GetActiveAttrib(s.id, uint32(i), maxLength+1, &length, &size, &xtype, &buf[0])
+		name := string(buf[:length])
+		s.attributes[name] = AttributeInfo{
+			Name:     name,
+			Location: gl.GetAttribLocation(s.id, gl.Str(name+"\x00")),
+			Type:     xtype,
+			Size:     size,
+		}
+	}
+
+	s.bindUniformBlocks()
+}
+
+// hasUniform reports whether the program uses the uniform.
+func (s *Shader) hasUniform(name string) bool {
+	_, ok := s.uniforms[name]
+	return ok
+}
+
+// uniformLocation returns the cached location of a uniform that a setter writes values of
+// type xtype to. Setters that target a missing uniform, or one of another type, are warned
+// about once and get location -1, which OpenGL ignores.
+func (s *Shader) uniformLocation(name string, xtype uint32) int32 {
+	info, ok := s.uniforms[name]
+	if !ok {
+		s.warnOnce(name, fmt.Sprintf("uniform %q does not exist or is not used by the shader", name))
+		return -1
+	}
+	if !uniformAccepts(xtype, info.Type) {
+		s.warnOnce(name, fmt.Sprintf("uniform %q is a %v, can't set it to a %v", name, glslTypeName(info.Type), glslTypeName(xtype)))
+		return -1
+	}
+	return info.Location
+}
+
+func (s *Shader) warnOnce(name string, message string) {
+	if s.warned[name] {
+		return
+	}
+	s.warned[name] = true
+	label := strings.Join(s.sourceFiles(), ", ")
+	if label == "" {
+		label = fmt.Sprintf("program %v", s.id)
+	}
+	log.Printf("shader %v: %v", label, message)
+}
+
+// uniformAccepts reports whether a value written with a glUniform* call for xtype can be
+// stored in a uniform of type target.
+func uniformAccepts(xtype, target uint32) bool {
+	if xtype == target {
+		return true
+	}
+	switch xtype {
+	case gl.INT:
+		// glUniform1i also sets booleans and selects texture units for samplers
+		return target == gl.BOOL || isSamplerType(target)
+	case gl.FLOAT:
+		return target == gl.BOOL
+	}
+	return false
+}
+
+func isSamplerType(xtype uint32) bool {
+	switch xtype {
+	case gl.SAMPLER_1D, gl.SAMPLER_2D, gl.SAMPLER_3D, gl.SAMPLER_CUBE,
+		gl.SAMPLER_1D_SHADOW, gl.SAMPLER_2D_SHADOW, gl.SAMPLER_CUBE_SHADOW,
+		gl.SAMPLER_2D_ARRAY, gl.SAMPLER_2D_ARRAY_SHADOW, gl.SAMPLER_2D_MULTISAMPLE,
+		gl.SAMPLER_BUFFER, gl.INT_SAMPLER_2D, gl.UNSIGNED_INT_SAMPLER_2D:
+		return true
+	}
+	return false
+}
+
+var glslTypeNames = map[uint32]string{
+	gl.FLOAT:               "float",
+	gl.FLOAT_VEC2:          "vec2",
+	gl.FLOAT_VEC3:          "vec3",
+	gl.FLOAT_VEC4:          "vec4",
+	gl.INT:                 "int",
+	gl.INT_VEC2:            "ivec2",
+	gl.INT_VEC3:            "ivec3",
+	gl.INT_VEC4:            "ivec4",
+	gl.UNSIGNED_INT:        "uint",
+	gl.BOOL:                "bool",
+	gl.FLOAT_MAT2:          "mat2",
+	gl.FLOAT_MAT3:          "mat3",
+	gl.FLOAT_MAT4:          "mat4",
+	gl.SAMPLER_2D:          "sampler2D",
+	gl.SAMPLER_3D:          "sampler3D",
+	gl.SAMPLER_CUBE:        "samplerCube",
+	gl.SAMPLER_2D_SHADOW:   "sampler2DShadow",
+	gl.SAMPLER_2D_ARRAY:    "sampler2DArray",
+	gl.SAMPLER_CUBE_SHADOW: "samplerCubeShadow",
+}
+
+func glslTypeName(xtype uint32) string {
+	if name, ok := glslTypeNames[xtype]; ok {
+		return name
+	}
+	return fmt.Sprintf("type 0x%X", xtype)
+}
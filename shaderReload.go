@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+)
+
+// How often watched shaders look at their source files for changes.
+const shaderPollInterval = 250 * time.Millisecond
+
+// Shaders that reload themselves when their source files change on disk.
+var watchedShaders []*Shader
+
+// watch makes the shader recompile and relink in place whenever one of its source files
+// changes. Changes are picked up by reloadChangedShaders, which must be called from the
+// render loop because it talks to OpenGL.
+func (s *Shader) watch() {
+	if s.modTimes != nil {
+		return
+	}
+	s.modTimes = make(map[string]time.Time)
+	s.values = make(map[string]uniformValue)
+	s.recordModTimes()
+	watchedShaders = append(watchedShaders, s)
+}
+
+// recordModTimes remembers the current modification time of every source file.
+func (s *Shader) recordModTimes() {
+	for _, path := range s.sourceFiles() {
+		if info, err := os.Stat(path); err == nil {
+			s.modTimes[path] = info.ModTime()
+		}
+	}
+}
+
+// reloadChangedShaders rebuilds every watched shader whose sources changed since the last poll.
+func reloadChangedShaders() {
+	for _, s := range watchedShaders {
+		s.reloadIfChanged()
+	}
+}
+
+// sourceFiles lists the files the program is built from, including the ones pulled in with
+// #include.
+func (s *Shader) sourceFiles() []string {
+	var files []string
+	seen := make(map[string]bool)
+	for _, stage := range s.stages {
+		for _, file := range stage.files {
+			if !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	return files
+}
+
+// reloadIfChanged polls the modification times of the shader sources and rebuilds the
+// program if any of them changed. A program that fails to build is reported and the previous
+// one is kept.
+func (s *Shader) reloadIfChanged() bool {
+	if time.Since(s.lastPoll) < shaderPollInterval {
+		return false
+	}
+	s.lastPoll = time.Now()
+
+	changed := false
+	for _, path := range s.sourceFiles() {
+		info, err := os.Stat(path)
+		if err != nil {
+			// editors often replace files by deleting them first, try again next poll
+			continue
+		}
+		if !info.ModTime().Equal(s.modTimes[path]) {
+			s.modTimes[path] = info.ModTime()
+			changed = true
+		}
+	}
+	if !changed {
+		return false
+	}
+
+	if err := s.reload(); err != nil {
+		log.Printf("shader reload failed, keeping previous program: %v", err)
+		return false
+	}
+	// the new sources may include files that weren't watched before
+	s.recordModTimes()
+	fmt.Printf("reloaded shader %v\n", s.sourceFiles())
+	return true
+}
+
+// reload builds a new program from the current sources and swaps it in place of the old one,
+// restoring the uniform values that were set on the old program.
+func (s *Shader) reload() error {
+	stages := make([]shaderStage, len(s.stages))
+	copy(stages, s.stages)
+	for i := range stages {
+		if err := stages[i].load(s.defines, os.ReadFile); err != nil {
+			return err
+		}
+	}
+
+	id, err := linkProgram(stages)
+	if err != nil {
+		return err
+	}
+
+	var current int32
+	gl.GetIntegerv(gl.CURRENT_PROGRAM, &current)
+
+	old := s.id
+	gl.DeleteProgram(old)
+	s.id = id
+	s.stages = stages
+
+	s.reflect()
+
+	gl.UseProgram(s.id)
+	for name, value := range s.values {
+		value.apply(s.uniformLocation(name, value.xtype))
+	}
+	if uint32(current) != old {
+		gl.UseProgram(uint32(current))
+	}
+	return nil
+}
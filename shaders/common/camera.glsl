@@ -0,0 +1,7 @@
+// Per frame camera data, filled from CameraBlock
+layout (std140) uniform Camera
+{
+    mat4 projection;
+    mat4 view;
+    vec3 viewPos;
+};
@@ -0,0 +1,26 @@
+// Scene lights, filled from LightsBlock
+#define MAX_POINT_LIGHTS 4
+
+struct DirLight {
+    vec3 direction;
+    vec3 ambient;
+    vec3 diffuse;
+    vec3 specular;
+};
+
+struct PointLight {
+    vec3 position;
+    vec3 ambient;
+    vec3 diffuse;
+    vec3 specular;
+    float constant;
+    float linear;
+    float quadratic;
+};
+
+layout (std140) uniform Lights
+{
+    DirLight dirLight;
+    PointLight pointLights[MAX_POINT_LIGHTS];
+    int numPointLights;
+};
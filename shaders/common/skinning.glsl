@@ -0,0 +1,25 @@
+// Skeletal animation, filled by Animator.upload
+#define MAX_BONES 100
+#define MAX_BONE_INFLUENCE 4
+
+layout (location = 5) in ivec4 boneIds;
+layout (location = 6) in vec4 weights;
+
+uniform mat4 finalBonesMatrices[MAX_BONES];
+
+// skinMatrix blends the bones of the vertex. Vertices without bones stay where they are.
+mat4 skinMatrix()
+{
+    mat4 skin = mat4(0.0);
+    float total = 0.0;
+    for (int i = 0; i < MAX_BONE_INFLUENCE; i++)
+    {
+        if (boneIds[i] < 0 || boneIds[i] >= MAX_BONES)
+            continue;
+        skin += finalBonesMatrices[boneIds[i]] * weights[i];
+        total += weights[i];
+    }
+    if (total == 0.0)
+        return mat4(1.0);
+    return skin;
+}
@@ -0,0 +1,29 @@
+#version 410 core
+out vec4 FragColor;
+
+in vec3 FragPos;
+in vec3 Normal;
+in vec2 TexCoords;
+
+#include "common/camera.glsl"
+
+struct Material {
+    vec3 diffuseColor;
+    bool hasDiffuseMap;
+};
+
+uniform Material material;
+uniform sampler2D texture_diffuse1;
+
+void main()
+{
+    vec3 color = material.diffuseColor;
+    if (material.hasDiffuseMap)
+        color = texture(texture_diffuse1, TexCoords).rgb;
+
+    // light from the camera, so the side in view is always lit
+    vec3 norm = normalize(Normal);
+    vec3 lightDir = normalize(viewPos - FragPos);
+    float diffuse = abs(dot(norm, lightDir));
+    FragColor = vec4(color * (0.2 + 0.8 * diffuse), 1.0);
+}
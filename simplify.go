@@ -0,0 +1,423 @@
+package main
+
+import (
+	"math"
+	"sort"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// quadric sums the squared distances of a point to a set of planes, each weighted by the area
+// it stands for, as in Garland and Heckbert's "Surface Simplification Using Quadric Error
+// Metrics".
+type quadric struct {
+	a00, a01, a02, a11, a12, a22 float64
+	b0, b1, b2                   float64
+	c                            float64
+	// weight is the total weight of the planes
+	weight float64
+}
+
+// planeQuadric is the quadric of the plane through point with a normalized normal.
+func planeQuadric(normal, point mgl32.Vec3, weight float64) quadric {
+	a, b, c := float64(normal[0]), float64(normal[1]), float64(normal[2])
+	d := -(a*float64(point[0]) + b*float64(point[1]) + c*float64(point[2]))
+	return quadric{
+		a00: weight * a * a, a01: weight * a * b, a02: weight * a * c,
+		a11: weight * b * b, a12: weight * b * c,
+		a22: weight * c * c,
+		b0:  weight * a * d, b1: weight * b * d, b2: weight * c * d,
+		c:      weight * d * d,
+		weight: weight,
+	}
+}
+
+func (q *quadric) add(o quadric) {
+	q.a00 += o.a00
+	q.a01 += o.a01
+	q.a02 += o.a02
+	q.a11 += o.a11
+	q.a12 += o.a12
+	q.a22 += o.a22
+	q.b0 += o.b0
+	q.b1 += o.b1
+	q.b2 += o.b2
+	q.c += o.c
+	q.weight += o.weight
+}
+
+// error returns the mean squared distance of p to the planes.
+func (q *quadric) error(p mgl32.Vec3) float64 {
+	if q.weight == 0 {
+		return 0
+	}
+	x, y, z := float64(p[0]), float64(p[1]), float64(p[2])
+	e := q.a00*x*x + q.a11*y*y + q.a22*z*z +
+		2*(q.a01*x*y+q.a02*x*z+q.a12*y*z) +
+		2*(q.b0*x+q.b1*y+q.b2*z) + q.c
+	return max(e, 0) / q.weight
+}
+
+// borderWeight makes moving away from a border or seam cost more than moving away from a
+// face of the same size, so the outline of the mesh keeps its shape.
+const borderWeight = 10
+
+// borderCornerCos is the cosine of the sharpest turn a border can take at a vertex that slides
+// along it. Vertices at sharper corners are locked, or the corners would get cut off.
+const borderCornerCos = 0.5
+
+// vertexKind is what a vertex may collapse onto.
+type vertexKind uint8
+
+const (
+	// kindManifold vertices are inside a surface and can collapse onto any neighbour
+	kindManifold vertexKind = iota
+	// kindBorder vertices are on the open edge of a surface and slide along it
+	kindBorder
+	// kindSeam vertices have a twin at the same position with other attributes, like the
+	// other side of a UV seam or a hard edge. They slide along the seam with their twin.
+	kindSeam
+	// kindLocked vertices are where seams and borders meet, at sharp corners of a border or
+	// where the surface is tangled, and don't move
+	kindLocked
+)
+
+// edgeKey packs a directed edge into a map key.
+func edgeKey(a, b uint32) uint64 {
+	return uint64(a)<<32 | uint64(b)
+}
+
+// simplifier collapses edges of a mesh one after another. The vertices never move, vertices
+// are only dropped, so every level of detail can use the vertex buffer of the full mesh.
+type simplifier struct {
+	vertices []Vertex
+	// indices are the triangles left
+	indices []uint32
+	// root is the first vertex at the same position as a vertex, twin the next one in a ring
+	// of every vertex at it
+	root []uint32
+	twin []uint32
+	kind []vertexKind
+	// quadrics of the surface removed so far around every position, by root
+	quadrics []quadric
+	// error is the largest squared distance of a collapse so far
+	error float64
+}
+
+// newSimplifier works out the positions and kinds of the vertices and the quadrics of the
+// surface around them.
+func newSimplifier(vertices []Vertex, indices []uint32) *simplifier {
+	s := &simplifier{
+		vertices: vertices,
+		indices:  append([]uint32(nil), indices[:len(indices)/3*3]...),
+		root:     make([]uint32, len(vertices)),
+		twin:     make([]uint32, len(vertices)),
+		kind:     make([]vertexKind, len(vertices)),
+		quadrics: make([]quadric, len(vertices)),
+	}
+
+	// only vertices that are used count as twins
+	used := make([]bool, len(vertices))
+	for _, index := range s.indices {
+		used[index] = true
+	}
+	first := make(map[mgl32.Vec3]uint32, len(vertices))
+	for v := range vertices {
+		s.root[v] = uint32(v)
+		s.twin[v] = uint32(v)
+		if !used[v] {
+			continue
+		}
+		r, ok := first[vertices[v].Position]
+		if !ok {
+			first[vertices[v].Position] = uint32(v)
+			continue
+		}
+		s.root[v] = r
+		s.twin[v] = s.twin[r]
+		s.twin[r] = uint32(v)
+	}
+
+	edges, rootEdges := s.edges()
+	// open edges have no triangle on the other side. Open edges between vertices are seams
+	// or borders, open edges between positions are borders.
+	openOut := make([]int, len(vertices))
+	openIn := make([]int, len(vertices))
+	rootOpenOut := make([]int, len(vertices))
+	rootOpenIn := make([]int, len(vertices))
+	// direction of the open edges between positions, by root
+	borderOut := make([]mgl32.Vec3, len(vertices))
+	borderIn := make([]mgl32.Vec3, len(vertices))
+	tangled := make([]bool, len(vertices))
+	for t := 0; t < len(s.indices); t += 3 {
+		corners := s.indices[t : t+3]
+		a, b, c := vertices[corners[0]].Position, vertices[corners[1]].Position, vertices[corners[2]].Position
+		normal := b.Sub(a).Cross(c.Sub(a))
+		area := float64(normal.Len()) / 2
+		normal = normalizeOrZero(normal)
+		for _, corner := range corners {
+			s.quadrics[s.root[corner]].add(planeQuadric(normal, a, area))
+		}
+
+		for i := 0; i < 3; i++ {
+			from, to := corners[i], corners[(i+1)%3]
+			if edges[edgeKey(from, to)] > 1 {
+				tangled[from], tangled[to] = true, true
+			}
+			if edges[edgeKey(to, from)] == 0 {
+				openOut[from]++
+				openIn[to]++
+				// keep the edge in place with a plane through it, standing on the face
+				edge := vertices[to].Position.Sub(vertices[from].Position)
+				length := float64(edge.Len())
+				border := planeQuadric(normalizeOrZero(edge.Cross(normal)), vertices[from].Position, length*length*borderWeight)
+				s.quadrics[s.root[from]].add(border)
+				s.quadrics[s.root[to]].add(border)
+			}
+			rootFrom, rootTo := s.root[from], s.root[to]
+			if rootEdges[edgeKey(rootTo, rootFrom)] == 0 {
+				rootOpenOut[rootFrom]++
+				rootOpenIn[rootTo]++
+				direction := normalizeOrZero(vertices[to].Position.Sub(vertices[from].Position))
+				borderOut[rootFrom] = direction
+				borderIn[rootTo] = direction
+			}
+		}
+	}
+
+	for v := range vertices {
+		r := s.root[v]
+		twins := 1
+		for w := s.twin[v]; w != uint32(v); w = s.twin[w] {
+			twins++
+		}
+		switch {
+		case tangled[v]:
+			s.kind[v] = kindLocked
+		case twins == 1 && rootOpenOut[r] == 0 && rootOpenIn[r] == 0:
+			s.kind[v] = kindManifold
+		case twins == 1 && rootOpenOut[r] == 1 && rootOpenIn[r] == 1:
+			s.kind[v] = kindBorder
+			if borderIn[r].Dot(borderOut[r]) < borderCornerCos {
+				s.kind[v] = kindLocked
+			}
+		case twins == 2 && rootOpenOut[r] == 0 && rootOpenIn[r] == 0 &&
+			openOut[v] == 1 && openIn[v] == 1 && openOut[s.twin[v]] == 1 && openIn[s.twin[v]] == 1:
+			s.kind[v] = kindSeam
+		default:
+			s.kind[v] = kindLocked
+		}
+	}
+	return s
+}
+
+// edges counts the directed edges of the triangles left, between vertices and between their
+// positions.
+func (s *simplifier) edges() (edges, rootEdges map[uint64]int) {
+	edges = make(map[uint64]int, len(s.indices))
+	rootEdges = make(map[uint64]int, len(s.indices))
+	for t := 0; t < len(s.indices); t += 3 {
+		for i := 0; i < 3; i++ {
+			from, to := s.indices[t+i], s.indices[t+(i+1)%3]
+			edges[edgeKey(from, to)]++
+			rootEdges[edgeKey(s.root[from], s.root[to])]++
+		}
+	}
+	return edges, rootEdges
+}
+
+// collapse moves vertex from onto vertex to, and for seams its twin onto the twin of to.
+type collapse struct {
+	from, to         uint32
+	twinFrom, twinTo uint32
+	seam             bool
+	cost             float64
+}
+
+// canCollapse finds the collapse of from onto to, if the kinds of the vertices and the edge
+// between them allow it.
+func (s *simplifier) canCollapse(from, to uint32, edges, rootEdges map[uint64]int) (collapse, bool) {
+	c := collapse{from: from, to: to}
+	if s.root[from] == s.root[to] {
+		return c, false
+	}
+	switch s.kind[from] {
+	case kindManifold:
+	case kindBorder:
+		// only along the border, onto another vertex that stays on it
+		if s.kind[to] != kindBorder && s.kind[to] != kindLocked {
+			return c, false
+		}
+		if rootEdges[edgeKey(s.root[from], s.root[to])]+rootEdges[edgeKey(s.root[to], s.root[from])] != 1 {
+			return c, false
+		}
+	case kindSeam:
+		// only along the seam, and the twin has to follow along the other side of it
+		if s.kind[to] != kindSeam && s.kind[to] != kindLocked {
+			return c, false
+		}
+		if edges[edgeKey(from, to)]+edges[edgeKey(to, from)] != 1 {
+			return c, false
+		}
+		c.seam = true
+		c.twinFrom = s.twin[from]
+		found := false
+		for w := s.twin[to]; w != to; w = s.twin[w] {
+			if edges[edgeKey(c.twinFrom, w)]+edges[edgeKey(w, c.twinFrom)] == 1 {
+				c.twinTo = w
+				found = true
+				break
+			}
+		}
+		if !found {
+			return c, false
+		}
+	default:
+		return c, false
+	}
+	c.cost = s.quadrics[s.root[from]].error(s.vertices[to].Position)
+	return c, true
+}
+
+// flips reports whether moving vertex from onto to turns any of its triangles over. Triangles
+// that use the position of to disappear and don't count.
+func (s *simplifier) flips(from, to uint32, triangles []int) bool {
+	target := s.vertices[to].Position
+	for _, t := range triangles {
+		corners := s.indices[3*t : 3*t+3]
+		var before, after [3]mgl32.Vec3
+		gone := false
+		for i, corner := range corners {
+			before[i] = s.vertices[corner].Position
+			after[i] = before[i]
+			if corner == from {
+				after[i] = target
+			}
+			if s.root[corner] == s.root[to] {
+				gone = true
+			}
+		}
+		if gone {
+			continue
+		}
+		normalBefore := before[1].Sub(before[0]).Cross(before[2].Sub(before[0]))
+		normalAfter := after[1].Sub(after[0]).Cross(after[2].Sub(after[0]))
+		if normalBefore.Dot(normalAfter) <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// simplify collapses edges, cheapest first, until at most targetTriangles triangles are left
+// or no edge can go without tearing the mesh or turning triangles over.
+func (s *simplifier) simplify(targetTriangles int) {
+	for len(s.indices)/3 > targetTriangles {
+		edges, rootEdges := s.edges()
+		var candidates []collapse
+		for t := 0; t < len(s.indices); t += 3 {
+			for i := 0; i < 3; i++ {
+				a, b := s.indices[t+i], s.indices[t+(i+1)%3]
+				if c, ok := s.canCollapse(a, b, edges, rootEdges); ok {
+					candidates = append(candidates, c)
+				}
+				if c, ok := s.canCollapse(b, a, edges, rootEdges); ok {
+					candidates = append(candidates, c)
+				}
+			}
+		}
+		if len(candidates) == 0 {
+			return
+		}
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].cost < candidates[j].cost })
+
+		// the triangles around every vertex
+		offsets := make([]int, len(s.vertices)+1)
+		for _, index := range s.indices {
+			offsets[index+1]++
+		}
+		for v := range s.vertices {
+			offsets[v+1] += offsets[v]
+		}
+		around := make([]int, len(s.indices))
+		filled := append([]int(nil), offsets[:len(s.vertices)]...)
+		for i, index := range s.indices {
+			around[filled[index]] = i / 3
+			filled[index]++
+		}
+		trianglesAround := func(v uint32) []int {
+			return around[offsets[v]:offsets[v+1]]
+		}
+
+		// a collapse takes away about two triangles. Do a few more than needed at this cost,
+		// as some will be turned down.
+		excess := len(s.indices)/3 - targetTriangles
+		limit := max(1, excess/2)
+		threshold := candidates[min(len(candidates)-1, limit*3/2)].cost
+
+		remap := make([]uint32, len(s.vertices))
+		for v := range remap {
+			remap[v] = uint32(v)
+		}
+		// positions whose triangles changed this pass, and can't be checked against the
+		// old triangles any more
+		touched := make([]bool, len(s.vertices))
+		removed := 0
+		for _, c := range candidates {
+			if c.cost > threshold || removed >= excess {
+				break
+			}
+			if touched[s.root[c.from]] || touched[s.root[c.to]] {
+				continue
+			}
+			if s.flips(c.from, c.to, trianglesAround(c.from)) {
+				continue
+			}
+			if c.seam && s.flips(c.twinFrom, c.twinTo, trianglesAround(c.twinFrom)) {
+				continue
+			}
+
+			remap[c.from] = c.to
+			sides := []uint32{c.from}
+			if c.seam {
+				remap[c.twinFrom] = c.twinTo
+				sides = append(sides, c.twinFrom)
+			}
+			for _, side := range sides {
+				for _, t := range trianglesAround(side) {
+					gone := false
+					for _, corner := range s.indices[3*t : 3*t+3] {
+						touched[s.root[corner]] = true
+						if s.root[corner] == s.root[c.to] {
+							gone = true
+						}
+					}
+					if gone {
+						removed++
+					}
+				}
+			}
+			s.quadrics[s.root[c.to]].add(s.quadrics[s.root[c.from]])
+			s.error = max(s.error, c.cost)
+		}
+		if removed == 0 {
+			return
+		}
+
+		// drop the triangles that collapsed to a line
+		kept := s.indices[:0]
+		for t := 0; t < len(s.indices); t += 3 {
+			a, b, c := remap[s.indices[t]], remap[s.indices[t+1]], remap[s.indices[t+2]]
+			if s.root[a] == s.root[b] || s.root[b] == s.root[c] || s.root[a] == s.root[c] {
+				continue
+			}
+			kept = append(kept, a, b, c)
+		}
+		s.indices = kept
+	}
+}
+
+// distance returns how far the simplified surface is from the original one, roughly.
+func (s *simplifier) distance() float32 {
+	return float32(math.Sqrt(s.error))
+}
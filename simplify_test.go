@@ -0,0 +1,218 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// seamGrid returns a flat size x size grid of quads on the XY plane, split down the middle by a
+// UV seam: the vertices on x = size/2 are there twice, with u = 1 for the left half and u = 0
+// for the right half.
+func seamGrid(size int) ([]Vertex, []uint32) {
+	var vertices []Vertex
+	var indices []uint32
+	half := size / 2
+	for side := 0; side < 2; side++ {
+		first := len(vertices)
+		for y := 0; y <= size; y++ {
+			for x := side * half; x <= half+side*half; x++ {
+				vertices = append(vertices, Vertex{
+					Position:  mgl32.Vec3{float32(x), float32(y), 0},
+					Normal:    mgl32.Vec3{0, 0, 1},
+					TexCoords: mgl32.Vec2{float32(x-side*half) / float32(half), float32(y) / float32(size)},
+				})
+			}
+		}
+		at := func(x, y int) uint32 { return uint32(first + y*(half+1) + x) }
+		for y := 0; y < size; y++ {
+			for x := 0; x < half; x++ {
+				// counter-clockwise seen from +z
+				indices = append(indices, at(x, y), at(x+1, y), at(x+1, y+1), at(x, y), at(x+1, y+1), at(x, y+1))
+			}
+		}
+	}
+	return vertices, indices
+}
+
+// checkSeamGrid checks that a simplified seamGrid still covers the whole square without
+// triangles turned over, that its outline is the square's, and that both sides of the seam
+// still meet at the same positions with their own UVs.
+func checkSeamGrid(t *testing.T, vertices []Vertex, indices []uint32, size int) {
+	t.Helper()
+	half := float32(size / 2)
+	onOutline := func(p mgl32.Vec3) bool {
+		return p.X() == 0 || p.X() == float32(size) || p.Y() == 0 || p.Y() == float32(size)
+	}
+
+	var area [2]float32
+	// the seam positions used by each side, and the directed edges by position
+	var seam [2]map[mgl32.Vec3]bool
+	seam[0], seam[1] = make(map[mgl32.Vec3]bool), make(map[mgl32.Vec3]bool)
+	edges := make(map[[2]mgl32.Vec3]bool)
+	used := make(map[mgl32.Vec3]bool)
+	for tri := 0; tri < len(indices)/3; tri++ {
+		corners := indices[3*tri : 3*tri+3]
+		a, b, c := vertices[corners[0]].Position, vertices[corners[1]].Position, vertices[corners[2]].Position
+		side := 0
+		if a.X()+b.X()+c.X() > 3*half {
+			side = 1
+		}
+		triangleArea := b.Sub(a).Cross(c.Sub(a)).Z() / 2
+		if triangleArea <= 0 {
+			t.Fatalf("triangle %v is turned over or degenerate: %v %v %v", tri, a, b, c)
+		}
+		area[side] += triangleArea
+		for i, corner := range corners {
+			v := vertices[corner]
+			used[v.Position] = true
+			edges[[2]mgl32.Vec3{v.Position, vertices[corners[(i+1)%3]].Position}] = true
+			if side == 0 && v.Position.X() > half || side == 1 && v.Position.X() < half {
+				t.Fatalf("triangle %v crosses the seam", tri)
+			}
+			if v.Position.X() != half {
+				continue
+			}
+			seam[side][v.Position] = true
+			// the left side has to keep using its own copy of the seam, and the right its own
+			if want := float32(1 - side); v.TexCoords.X() != want {
+				t.Errorf("side %v uses a seam vertex with u = %v", side, v.TexCoords.X())
+			}
+		}
+	}
+
+	for side := range area {
+		if want := half * float32(size); math.Abs(float64(area[side]-want)) > 1e-3 {
+			t.Errorf("side %v covers %v, want %v", side, area[side], want)
+		}
+	}
+	for p := range seam[0] {
+		if !seam[1][p] {
+			t.Errorf("seam is torn at %v, only the left side uses it", p)
+		}
+	}
+	for p := range seam[1] {
+		if !seam[0][p] {
+			t.Errorf("seam is torn at %v, only the right side uses it", p)
+		}
+	}
+	for _, corner := range []mgl32.Vec3{{0, 0, 0}, {float32(size), 0, 0}, {0, float32(size), 0}, {float32(size), float32(size), 0}, {half, 0, 0}, {half, float32(size), 0}} {
+		if !used[corner] {
+			t.Errorf("locked vertex at %v is gone", corner)
+		}
+	}
+	// edges without a triangle on the other side make up the outline
+	for edge := range edges {
+		if edges[[2]mgl32.Vec3{edge[1], edge[0]}] {
+			continue
+		}
+		if !onOutline(edge[0]) || !onOutline(edge[1]) || edge[0].X() != edge[1].X() && edge[0].Y() != edge[1].Y() {
+			t.Errorf("open edge %v -> %v isn't on the outline", edge[0], edge[1])
+		}
+	}
+}
+
+func TestSimplifierKinds(t *testing.T) {
+	const size = 4
+	vertices, indices := seamGrid(size)
+	s := newSimplifier(vertices, indices)
+	for v, vertex := range vertices {
+		p := vertex.Position
+		onX := p.X() == 0 || p.X() == size
+		onY := p.Y() == 0 || p.Y() == size
+		onSeam := p.X() == size/2
+		var want vertexKind
+		switch {
+		case onSeam && onY, onX && onY:
+			want = kindLocked
+		case onSeam:
+			want = kindSeam
+		case onX || onY:
+			want = kindBorder
+		default:
+			want = kindManifold
+		}
+		if s.kind[v] != want {
+			t.Errorf("vertex %v at %v is kind %v, want %v", v, p, s.kind[v], want)
+		}
+	}
+}
+
+func TestSimplifyGrid(t *testing.T) {
+	const size = 8
+	vertices, indices := seamGrid(size)
+	triangles := len(indices) / 3
+
+	s := newSimplifier(vertices, indices)
+	previous := triangles
+	for _, target := range []int{triangles / 2, triangles / 4, triangles / 8} {
+		s.simplify(target)
+		left := len(s.indices) / 3
+		if left > target {
+			t.Errorf("simplified to %v triangles, want at most %v", left, target)
+		}
+		if left == 0 || left >= previous {
+			t.Fatalf("simplified from %v to %v triangles", previous, left)
+		}
+		previous = left
+		checkSeamGrid(t, vertices, s.indices, size)
+	}
+	// with its corners locked, each half can't get simpler than two triangles
+	s.simplify(1)
+	if left := len(s.indices) / 3; left != 4 {
+		t.Errorf("simplified all the way to %v triangles, want 4", left)
+	}
+	checkSeamGrid(t, vertices, s.indices, size)
+	// the grid is flat, nothing moved off it
+	if s.distance() > 1e-6 {
+		t.Errorf("distance = %v, want 0", s.distance())
+	}
+}
+
+func TestMeshLODs(t *testing.T) {
+	const size = 8
+	vertices, indices := seamGrid(size)
+	mesh := Mesh{vertices: vertices, indices: indices}
+	mesh.computeBounds()
+	m := newModel("", ModelOptions{LODRatios: []float32{0.5, 0.25, 0.1}})
+
+	lods := m.meshLODs(vertices, indices, mesh.sphere)
+	if len(lods) != 3 {
+		t.Fatalf("got %v levels, want 3", len(lods))
+	}
+	triangles := len(indices) / 3
+	total := 0
+	for i, lod := range lods {
+		count := len(lod.indices) / 3
+		want := int(float32(triangles) * m.options.LODRatios[i])
+		if count == 0 || count > want {
+			t.Errorf("level %v has %v triangles, want 1 to %v", i, count, want)
+		}
+		checkSeamGrid(t, vertices, lod.indices, size)
+		total += count
+	}
+	if m.stats.LODTriangles != total {
+		t.Errorf("LODTriangles = %v, want %v", m.stats.LODTriangles, total)
+	}
+}
+
+func TestMeshLODsKeepATriangle(t *testing.T) {
+	// a closed tetrahedron can collapse all the way down to nothing
+	vertices := []Vertex{
+		{Position: mgl32.Vec3{0, 0, 0}},
+		{Position: mgl32.Vec3{1, 0, 0}},
+		{Position: mgl32.Vec3{0, 1, 0}},
+		{Position: mgl32.Vec3{0, 0, 1}},
+	}
+	indices := []uint32{0, 2, 1, 0, 1, 3, 0, 3, 2, 1, 2, 3}
+	mesh := Mesh{vertices: vertices, indices: indices}
+	mesh.computeBounds()
+	m := newModel("", ModelOptions{LODRatios: []float32{0.5, 0.1, 0.01}})
+
+	for i, lod := range m.meshLODs(vertices, indices, mesh.sphere) {
+		if len(lod.indices) == 0 {
+			t.Errorf("level %v has no triangles", i)
+		}
+	}
+}
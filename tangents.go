@@ -0,0 +1,140 @@
+package main
+
+import (
+	"math"
+
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// generateTangents fills in the Tangent and Bitangent of every vertex from the texture
+// coordinates, following the MikkTSpace conventions: per face tangents are projected onto the
+// plane of the vertex normal and weighted by the angle of the face at the vertex, and the
+// bitangent is the cross product of the normal and tangent, flipped by the handedness of the
+// texture mapping.
+//
+// A vertex shared by faces with mirrored texture coordinates can't have one tangent space that
+// suits both, so it's split in two. That's why the vertices and indices may change.
+func generateTangents(vertices []Vertex, indices []uint32) ([]Vertex, []uint32) {
+	type corner struct {
+		vertex uint32
+		// mirrored texture coordinates use a left handed tangent space
+		mirrored bool
+	}
+	tangents := make(map[corner]mgl32.Vec3)
+	bitangents := make(map[corner]mgl32.Vec3)
+	corners := make([]corner, len(indices))
+
+	for t := 0; t+2 < len(indices); t += 3 {
+		i0, i1, i2 := indices[t], indices[t+1], indices[t+2]
+		v0, v1, v2 := vertices[i0], vertices[i1], vertices[i2]
+
+		edge1 := v1.Position.Sub(v0.Position)
+		edge2 := v2.Position.Sub(v0.Position)
+		deltaUV1 := v1.TexCoords.Sub(v0.TexCoords)
+		deltaUV2 := v2.TexCoords.Sub(v0.TexCoords)
+
+		det := deltaUV1.X()*deltaUV2.Y() - deltaUV2.X()*deltaUV1.Y()
+		var tangent, bitangent mgl32.Vec3
+		mirrored := false
+		if math.Abs(float64(det)) > 1e-12 {
+			f := 1 / det
+			tangent = edge1.Mul(deltaUV2.Y()).Sub(edge2.Mul(deltaUV1.Y())).Mul(f)
+			bitangent = edge2.Mul(deltaUV1.X()).Sub(edge1.Mul(deltaUV2.X())).Mul(f)
+			faceNormal := edge1.Cross(edge2)
+			mirrored = faceNormal.Cross(tangent).Dot(bitangent) < 0
+		}
+
+		for k, index := range [3]uint32{i0, i1, i2} {
+			c := corner{vertex: index, mirrored: mirrored}
+			corners[t+k] = c
+			if tangent.Len() == 0 {
+				// degenerate texture coordinates add nothing, the vertex gets a tangent
+				// from its other faces or a made up one
+				continue
+			}
+
+			// weight by the angle of the face at this corner
+			position := vertices[index].Position
+			a := vertices[indices[t+(k+1)%3]].Position.Sub(position)
+			b := vertices[indices[t+(k+2)%3]].Position.Sub(position)
+			weight := angleBetween(a, b)
+
+			normal := vertices[index].Normal
+			tangents[c] = tangents[c].Add(normalizeOrZero(projectOntoPlane(tangent, normal)).Mul(weight))
+			bitangents[c] = bitangents[c].Add(normalizeOrZero(projectOntoPlane(bitangent, normal)).Mul(weight))
+		}
+	}
+
+	// every vertex keeps its index for the first handedness it's used with, the other one
+	// gets a copy
+	result := append([]Vertex(nil), vertices...)
+	newIndex := make(map[corner]uint32)
+	used := make([]bool, len(vertices))
+	newIndices := make([]uint32, len(indices))
+	for i, c := range corners {
+		index, ok := newIndex[c]
+		if !ok {
+			if !used[c.vertex] {
+				used[c.vertex] = true
+				index = c.vertex
+			} else {
+				index = uint32(len(result))
+				result = append(result, vertices[c.vertex])
+			}
+			newIndex[c] = index
+			result[index].Tangent, result[index].Bitangent = tangentFrame(result[index].Normal, tangents[c], bitangents[c], c.mirrored)
+		}
+		newIndices[i] = index
+	}
+	return result, newIndices
+}
+
+// tangentFrame turns the summed up tangents of a vertex into an orthonormal tangent and
+// bitangent.
+func tangentFrame(normal, tangent, bitangent mgl32.Vec3, mirrored bool) (mgl32.Vec3, mgl32.Vec3) {
+	if normal.Len() == 0 {
+		// without a normal the best we can do is the averaged tangents
+		return normalizeOrZero(tangent), normalizeOrZero(bitangent)
+	}
+	normal = normal.Normalize()
+	// Gram-Schmidt, remove any part of the tangent along the normal
+	tangent = projectOntoPlane(tangent, normal)
+	if tangent.Len() < 1e-6 {
+		// no usable texture coordinates, pick any direction in the plane of the normal
+		tangent = anyPerpendicular(normal)
+	}
+	tangent = tangent.Normalize()
+
+	handedness := float32(1)
+	if mirrored {
+		handedness = -1
+	}
+	return tangent, normal.Cross(tangent).Mul(handedness)
+}
+
+// projectOntoPlane removes the part of v along the normal n. A zero n leaves v as is.
+func projectOntoPlane(v, n mgl32.Vec3) mgl32.Vec3 {
+	if n.Len() == 0 {
+		return v
+	}
+	n = n.Normalize()
+	return v.Sub(n.Mul(v.Dot(n)))
+}
+
+// anyPerpendicular returns a unit vector perpendicular to n.
+func anyPerpendicular(n mgl32.Vec3) mgl32.Vec3 {
+	axis := mgl32.Vec3{1, 0, 0}
+	if math.Abs(float64(n.X())) > 0.9 {
+		axis = mgl32.Vec3{0, 1, 0}
+	}
+	return n.Cross(axis).Normalize()
+}
+
+// angleBetween returns the angle between two vectors in radians, 0 if either is zero.
+func angleBetween(a, b mgl32.Vec3) float32 {
+	if a.Len() == 0 || b.Len() == 0 {
+		return 0
+	}
+	cos := a.Normalize().Dot(b.Normalize())
+	return float32(math.Acos(float64(mgl32.Clamp(cos, -1, 1))))
+}
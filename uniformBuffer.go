@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/go-gl/mathgl/mgl32"
+)
+
+// Binding points of the uniform blocks shared by all shaders. A shader that declares a block
+// with one of these names is hooked up to its binding point when it links, so the order
+// shaders and buffers are created in doesn't matter.
+const (
+	CameraBlockBinding uint32 = iota
+	LightsBlockBinding
+)
+
+var uniformBlockBindings = map[string]uint32{
+	"Camera": CameraBlockBinding,
+	"Lights": LightsBlockBinding,
+}
+
+// Every shader built so far, so blocks that get a binding point later can be hooked up to
+// programs that already linked.
+var uniformBlockShaders []*Shader
+
+// UniformBuffer is a uniform buffer object holding the data of one uniform block. It is
+// bound to the binding point of the block so every program using the block sees it.
+type UniformBuffer struct {
+	id      uint32
+	name    string
+	binding uint32
+	size    int
+	data    []byte
+}
+
+// NewUniformBuffer creates a buffer for the named uniform block, sized for the std140 layout
+// of value. value must be a struct; see packStd140 for the supported field types.
+func NewUniformBuffer(blockName string, value any) *UniformBuffer {
+	binding, ok := uniformBlockBindings[blockName]
+	if !ok {
+		// new blocks get the next free binding point
+		binding = uint32(len(uniformBlockBindings))
+		uniformBlockBindings[blockName] = binding
+		// programs that linked before didn't know the block and left it at binding 0,
+		// which is the camera's
+		for _, s := range uniformBlockShaders {
+			s.bindUniformBlocks()
+		}
+	}
+
+	size, _ := std140Layout(reflect.TypeOf(value))
+	ub := &UniformBuffer{name: blockName, binding: binding, size: size, data: make([]byte, size)}
+	gl.GenBuffers(1, &ub.id)
+	gl.BindBuffer(gl.UNIFORM_BUFFER, ub.id)
+	gl.BufferData(gl.UNIFORM_BUFFER, size, nil, gl.DYNAMIC_DRAW)
+	gl.BindBuffer(gl.UNIFORM_BUFFER, 0)
+	// link the whole buffer to the binding point
+	gl.BindBufferRange(gl.UNIFORM_BUFFER, binding, ub.id, 0, size)
+
+	ub.update(value)
+	return ub
+}
+
+// update uploads a new value for the block. It must have the type the buffer was created with.
+func (ub *UniformBuffer) update(value any) {
+	clear(ub.data)
+	packStd140(ub.data, 0, reflect.ValueOf(value))
+	gl.BindBuffer(gl.UNIFORM_BUFFER, ub.id)
+	gl.BufferSubData(gl.UNIFORM_BUFFER, 0, ub.size, gl.Ptr(ub.data))
+	gl.BindBuffer(gl.UNIFORM_BUFFER, 0)
+}
+
+// bindUniformBlocks points every uniform block of the program that has a known name at its
+// binding point.
+func (s *Shader) bindUniformBlocks() {
+	var count, maxLength int32
+	gl.GetProgramiv(s.id, gl.ACTIVE_UNIFORM_BLOCKS, &count)
+	gl.GetProgramiv(s.id, gl.ACTIVE_UNIFORM_BLOCK_MAX_NAME_LENGTH, &maxLength)
+	buf := make([]uint8, maxLength+1)
+	for i := int32(0); i < count; i++ {
+		var length int32
+		gl.GetActiveUniformBlockName(s.id, uint32(i), maxLength+1, &length, &buf[0])
+		name := string(buf[:length])
+		if binding, ok := uniformBlockBindings[name]; ok {
+			gl.UniformBlockBinding(s.id, uint32(i), binding)
+		}
+	}
+}
+
+var (
+	vec2Type = reflect.TypeOf(mgl32.Vec2{})
+	vec3Type = reflect.TypeOf(mgl32.Vec3{})
+	vec4Type = reflect.TypeOf(mgl32.Vec4{})
+	mat3Type = reflect.TypeOf(mgl32.Mat3{})
+	mat4Type = reflect.TypeOf(mgl32.Mat4{})
+)
+
+// std140Layout returns the size and base alignment of a Go type laid out by the std140 rules.
+// Supported types are float32, int32, uint32, bool, the mgl32 vectors, Mat3, Mat4, and arrays
+// and structs of those.
+func std140Layout(t reflect.Type) (size, align int) {
+	switch t {
+	case vec2Type:
+		return 8, 8
+	case vec3Type:
+		return 12, 16
+	case vec4Type:
+		return 16, 16
+	case mat3Type:
+		// stored as 3 columns of vec4
+		return 48, 16
+	case mat4Type:
+		return 64, 16
+	}
+
+	switch t.Kind() {
+	case reflect.Float32, reflect.Int32, reflect.Uint32, reflect.Bool:
+		return 4, 4
+	case reflect.Array:
+		// every element is aligned like a vec4
+		elementSize, _ := std140Layout(t.Elem())
+		stride := roundUp(elementSize, 16)
+		return stride * t.Len(), 16
+	case reflect.Struct:
+		offset := 0
+		align = 0
+		for i := 0; i < t.NumField(); i++ {
+			fieldSize, fieldAlign := std140Layout(t.Field(i).Type)
+			offset = roundUp(offset, fieldAlign) + fieldSize
+			align = max(align, fieldAlign)
+		}
+		// structs are aligned like a vec4 and padded to a multiple of it
+		align = roundUp(align, 16)
+		return roundUp(offset, align), align
+	}
+	panic(fmt.Sprintf("std140: unsupported type %v", t))
+}
+
+// packStd140 writes v into buf at offset using the std140 layout. It returns the offset
+// after v.
+func packStd140(buf []byte, offset int, v reflect.Value) int {
+	t := v.Type()
+	switch t {
+	case vec2Type, vec3Type, vec4Type:
+		for i := 0; i < v.Len(); i++ {
+			putFloat(buf, offset+4*i, float32(v.Index(i).Float()))
+		}
+		size, _ := std140Layout(t)
+		return offset + size
+	case mat3Type:
+		for column := 0; column < 3; column++ {
+			for row := 0; row < 3; row++ {
+				putFloat(buf, offset+16*column+4*row, float32(v.Index(3*column+row).Float()))
+			}
+		}
+		return offset + 48
+	case mat4Type:
+		for i := 0; i < 16; i++ {
+			putFloat(buf, offset+4*i, float32(v.Index(i).Float()))
+		}
+		return offset + 64
+	}
+
+	switch t.Kind() {
+	case reflect.Float32:
+		putFloat(buf, offset, float32(v.Float()))
+		return offset + 4
+	case reflect.Int32:
+		binary.NativeEndian.PutUint32(buf[offset:], uint32(int32(v.Int())))
+		return offset + 4
+	case reflect.Uint32:
+		binary.NativeEndian.PutUint32(buf[offset:], uint32(v.Uint()))
+		return offset + 4
+	case reflect.Bool:
+		var b uint32
+		if v.Bool() {
+			b = 1
+		}
+		binary.NativeEndian.PutUint32(buf[offset:], b)
+		return offset + 4
+	case reflect.Array:
+		elementSize, _ := std140Layout(t.Elem())
+		stride := roundUp(elementSize, 16)
+		for i := 0; i < v.Len(); i++ {
+			packStd140(buf, offset+i*stride, v.Index(i))
+		}
+		return offset + stride*v.Len()
+	case reflect.Struct:
+		size, _ := std140Layout(t)
+		start := offset
+		for i := 0; i < v.NumField(); i++ {
+			_, fieldAlign := std140Layout(t.Field(i).Type)
+			offset = packStd140(buf, roundUp(offset, fieldAlign), v.Field(i))
+		}
+		return start + size
+	}
+	panic(fmt.Sprintf("std140: unsupported type %v", t))
+}
+
+func putFloat(buf []byte, offset int, f float32) {
+	binary.NativeEndian.PutUint32(buf[offset:], math.Float32bits(f))
+}
+
+func roundUp(n, multiple int) int {
+	return (n + multiple - 1) / multiple * multiple
+}
+
+// CameraBlock is the per frame camera data of the Camera uniform block, see
+// shaders/common/camera.glsl.
+type CameraBlock struct {
+	Projection mgl32.Mat4
+	View       mgl32.Mat4
+	ViewPos    mgl32.Vec3
+}
+
+// MaxPointLights is the size of the point light array in the Lights uniform block.
+const MaxPointLights = 4
+
+type DirLight struct {
+	Direction mgl32.Vec3
+	Ambient   mgl32.Vec3
+	Diffuse   mgl32.Vec3
+	Specular  mgl32.Vec3
+}
+
+type PointLight struct {
+	Position  mgl32.Vec3
+	Ambient   mgl32.Vec3
+	Diffuse   mgl32.Vec3
+	Specular  mgl32.Vec3
+	Constant  float32
+	Linear    float32
+	Quadratic float32
+}
+
+// LightsBlock is the light data of the Lights uniform block, see shaders/common/lights.glsl.
+type LightsBlock struct {
+	DirLight       DirLight
+	PointLights    [MaxPointLights]PointLight
+	NumPointLights int32
+}